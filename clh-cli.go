@@ -9,7 +9,13 @@ import (
 
 func main() {
 	log.SetFormatter(&log.TextFormatter{})
-	log.SetOutput(os.Stdout)
+	log.SetOutput(os.Stderr)
 	log.SetLevel(log.InfoLevel)
-	cli.Execute()
+	err := cli.Execute()
+	if err != nil {
+		log.Error(err)
+	}
+	code := cli.ExitCode(err)
+	// Runs logrus exit handlers, e.g. closes the log file
+	log.Exit(code)
 }
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var whoamiCli = &cobra.Command{
+	Use:               "whoami",
+	Short:             "Print the current user",
+	Long:              "Print username and endpoint configured for the current context, --verify also logs in with them",
+	PersistentPreRunE: requireCredentials,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		username := viper.GetString(context + ".username")
+		if username == "" {
+			return fmt.Errorf("no username configured for context %q, use `clh config -u <username>`", context)
+		}
+
+		// Offline unless asked, the session token is left as it is
+		if verify, _ := cmd.Flags().GetBool("verify"); verify {
+			if err := readSecretKey(context); err != nil {
+				return err
+			}
+			loginUsername, secretKey, err := contextCredentials(context)
+			if err != nil {
+				return err
+			}
+			if secretKey == "" {
+				return fmt.Errorf("%w: no secret_key for context %q to verify, use `clh config -k -`", ErrUnauthenticated, context)
+			}
+			if _, err := requestToken(contextEndpoints(context), loginUsername, secretKey); err != nil {
+				return err
+			}
+		}
+		printResult(username + " @ " + contextEndpoints(context)[0])
+		return nil
+	},
+}
+
+var loginCli = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to CloudletHub",
+	Long:  "Exchange username and secret key of the current context for a session token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		// A token which can't be saved is of no use, e.g. with --read-only
+		if _, err := configSaveFile(); err != nil {
+			return err
+		}
+		if err := readSecretKey(context); err != nil {
+			return err
+		}
+		username, secretKey, err := contextCredentials(context)
+		if err != nil {
+			return err
+		}
+		if username == "" || secretKey == "" {
+			return fmt.Errorf("%w: username and secret_key (or credential_helper) are required for context %q, use `clh config`", ErrUnauthenticated, context)
+		}
+
+		token, err := requestToken(contextEndpoints(context), username, secretKey)
+		if err != nil {
+			return err
+		}
+
+		setConfig(context+".token", token)
+		if err := saveConfig(); err != nil {
+			return err
+		}
+
+		printResult("Logged in as " + username)
+		return nil
+	},
+}
+
+var logoutCli = &cobra.Command{
+	Use:   "logout",
+	Short: "Log out from CloudletHub",
+	Long:  "Forget the session token of the current context, no connection to the Hub is needed",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		unsetConfig(context + ".token")
+		if forget, _ := cmd.Flags().GetBool("forget-secret"); forget {
+			unsetConfig(context + ".secret_key")
+		}
+		if err := saveConfig(); err != nil {
+			return err
+		}
+
+		printResult("Logged out from context " + context)
+		return nil
+	},
+}
+
+var rotateKeyCli = &cobra.Command{
+	Use:               "rotate-key",
+	Short:             "Replace the secret key with a new one",
+	Long:              "Have the Hub issue a new secret key for the current context and save it, the old one stops working",
+	Args:              cobra.NoArgs,
+	PersistentPreRunE: requireCredentials,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		// Once rotated the old key is gone, so the new one must be savable
+		if dryRun, _ := rootCli.PersistentFlags().GetBool("dry-run"); dryRun {
+			return errors.New("rotate-key can't be undone, it doesn't support --dry-run")
+		}
+		if _, err := configSaveFile(); err != nil {
+			return err
+		}
+		if viper.GetString(context+".credential_helper") != "" {
+			return fmt.Errorf("secret key of context %q comes from its credential_helper, rotate it there", context)
+		}
+
+		c, err := contextClient()
+		if err != nil {
+			return err
+		}
+		var key struct {
+			SecretKey string `json:"secret_key"`
+		}
+		if err := c.Do("POST", "v1/keys/rotate", nil, &key); err != nil {
+			return fmt.Errorf("key rotation failed, old secret key is kept: %w", err)
+		}
+		if key.SecretKey == "" {
+			return errors.New("key rotation failed, old secret key is kept: no secret key received")
+		}
+
+		setConfig(context+".secret_key", key.SecretKey)
+		if err := saveConfig(); err != nil {
+			// Last chance not to lose the only valid key. It is printed once to the terminal,
+			// never logged, logs may end up in --log_file.
+			fmt.Fprintln(os.Stderr, "WARNING: the old secret key no longer works and the new one couldn't be saved, store it now:")
+			fmt.Fprintln(os.Stderr, key.SecretKey)
+			return fmt.Errorf("secret key rotated but not saved: %w", err)
+		}
+
+		printResult("Secret key of context " + context + " rotated")
+		return nil
+	},
+}
+
+// requestToken exchanges credentials for a session token
+func requestToken(endpoints []string, username, secretKey string) (string, error) {
+	c, err := newAPIClient(endpoints, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	credentials := map[string]string{"username": username, "secret_key": secretKey}
+	if err := c.Do("POST", "v1/login", credentials, &session); err != nil {
+		return "", fmt.Errorf("login failed: %w", err)
+	}
+	if session.Token == "" {
+		return "", errors.New("login failed: no token received")
+	}
+	return session.Token, nil
+}
+
+func init() {
+	// Whoami
+
+	whoamiCli.Flags().BoolP("verify", "", false, "Check the credentials against the Hub")
+	rootCli.AddCommand(whoamiCli)
+
+	// Login
+
+	rootCli.AddCommand(loginCli)
+
+	// Logout
+
+	logoutCli.Flags().BoolP("forget-secret", "", false, "Remove the secret key as well")
+	rootCli.AddCommand(logoutCli)
+
+	// Config Rotate Key
+
+	configCli.AddCommand(rotateKeyCli)
+}
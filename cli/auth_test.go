@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const rotatedSecretKey = "n3w-s3cret"
+
+func rotatingHub(t *testing.T) *fakeHub {
+	hub := newFakeHub(t)
+	hub.handle("POST /v1/keys/rotate", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"secret_key": rotatedSecretKey})
+	})
+	return hub
+}
+
+func TestRotateKey(t *testing.T) {
+	hub := rotatingHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	e.mustRun("config", "rotate-key")
+	if config := e.readConfig(); !strings.Contains(config, "secret_key: "+rotatedSecretKey) {
+		t.Errorf("new secret key not saved:\n%s", config)
+	}
+}
+
+func TestRotateKeyFailedKeepsOldKey(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	if result := e.run("config", "rotate-key"); result.code != ExitAPI {
+		t.Errorf("exit code %d, expected %d: %s", result.code, ExitAPI, result.stderr)
+	}
+	if config := e.readConfig(); !strings.Contains(config, "secret_key: "+hubSecretKey) {
+		t.Errorf("old secret key not kept:\n%s", config)
+	}
+}
+
+// A key which couldn't be saved is shown once, it must not reach the logs
+func TestRotateKeyNotSaved(t *testing.T) {
+	hub := rotatingHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+	// The backup can't be written over a directory, so saving fails after the rotation
+	if err := os.Mkdir(e.configFile()+".bak", 0700); err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(e.home, "clh.log")
+
+	result := e.run("--log_file", logFile, "config", "rotate-key")
+	if result.code == 0 {
+		t.Error("rotate-key succeeded without saving")
+	}
+	if strings.Count(result.stderr, rotatedSecretKey) != 1 {
+		t.Errorf("new secret key not printed once: %q", result.stderr)
+	}
+	logs, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "not saved") || strings.Contains(string(logs), rotatedSecretKey) {
+		t.Errorf("unexpected logs: %q", logs)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("whoami")
+	if result.stdout != hubUsername+" @ "+hub.endpoint()+"\n" {
+		t.Errorf("unexpected output: %q", result.stdout)
+	}
+	if got := hub.received(); len(got) != 0 {
+		t.Errorf("whoami without --verify talked to the hub: %v", got)
+	}
+
+	e.mustRun("whoami", "--verify")
+	if result := e.run("whoami", "--verify", "--secret_key", "wrong"); result.code != ExitAuth {
+		t.Errorf("exit code %d for wrong credentials, expected %d: %s", result.code, ExitAuth, result.stderr)
+	}
+}
@@ -0,0 +1,462 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Endpoint of contexts without one, unless default_endpoint is set
+const defaultEndpoint = "https://api.cloudlethub.com/"
+
+// Backoff between retries of failed API requests
+const (
+	baseRetryDelay = 500 * time.Millisecond
+	maxRetryDelay  = 10 * time.Second
+)
+
+// Methods safe to repeat when a request fails
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// apiClient talks to the CloudletHub API on behalf of a context
+type apiClient struct {
+	// Endpoint in use, one of endpoints after a failover
+	baseURL   *url.URL
+	endpoints []*url.URL
+	username  string
+	secretKey string
+	token     string
+	userAgent string
+	http      *http.Client
+
+	// Retries on connection errors and 5xx responses
+	retries     int
+	retryUnsafe bool
+}
+
+// Client of the current context reused within a run, so connections are kept alive.
+// It is rebuilt when anything it was built from changes, see clientKey.
+var (
+	cachedClient    *apiClient
+	cachedClientKey string
+)
+
+// apiError is returned when the API responds with a non 2xx status
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("API error: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("API error: %d %s", e.StatusCode, e.Message)
+}
+
+// newAPIClient builds a client for endpoints tried in order, network settings come from global flags
+func newAPIClient(endpoints []string, username, secretKey string) (*apiClient, error) {
+	var urls []*url.URL
+	for _, endpoint := range endpoints {
+		if err := validateEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+		urls = append(urls, endpointURL(endpoint))
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%w: no endpoint given", ErrInvalidEndpoint)
+	}
+
+	timeout, err := requestTimeout()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	retries := viper.GetInt("retries")
+	if retries < 0 {
+		return nil, fmt.Errorf("invalid retries %d: must not be negative", retries)
+	}
+
+	return &apiClient{
+		baseURL:   urls[0],
+		endpoints: urls,
+		username:  username,
+		secretKey: secretKey,
+		userAgent: userAgent(),
+		http:      &http.Client{Timeout: timeout, Transport: transport},
+
+		retries:     retries,
+		retryUnsafe: viper.GetBool("retry_unsafe"),
+	}, nil
+}
+
+// endpointURL parses a valid endpoint, paths are resolved relative to it
+// so it must look like a directory
+func endpointURL(endpoint string) *url.URL {
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	u, _ := url.Parse(endpoint)
+	return u
+}
+
+// contextClient returns the client of the current context, built once per run
+func contextClient() (*apiClient, error) {
+	if err := validateContext(); err != nil {
+		return nil, err
+	}
+
+	context := viper.GetString("context")
+	if err := readSecretKey(context); err != nil {
+		return nil, err
+	}
+	username, secretKey, err := contextCredentials(context)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := contextEndpoints(context)
+	token := viper.GetString(context + ".token")
+	key := clientKey(context, endpoints, username, secretKey, token)
+	if cachedClient != nil && key == cachedClientKey {
+		return cachedClient, nil
+	}
+
+	c, err := newAPIClient(endpoints, username, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	c.token = token
+	cachedClient, cachedClientKey = c, key
+	return c, nil
+}
+
+// clientKey sums up the settings a client is built from, e.g. a new token after
+// `clh login` or another --context in the same run give another key
+func clientKey(context string, endpoints []string, username, secretKey, token string) string {
+	settings := []interface{}{context, endpoints, username, secretKey, token}
+	for _, key := range []string{"timeout", "proxy", "insecure", "cacert", "retries", "retry_unsafe"} {
+		settings = append(settings, viper.GetString(key))
+	}
+	return fmt.Sprintf("%q", settings)
+}
+
+// requireContext is a pre-run hook of commands talking to the API without credentials
+func requireContext(cmd *cobra.Command, args []string) error {
+	return validateContext()
+}
+
+// requireCredentials is a pre-run hook of commands talking to the API as a user,
+// so missing credentials fail early with a hint instead of deep in a request
+func requireCredentials(cmd *cobra.Command, args []string) error {
+	if err := validateContext(); err != nil {
+		return err
+	}
+
+	context := viper.GetString("context")
+	if viper.GetString(context+".token") != "" || viper.GetString(context+".credential_helper") != "" {
+		return nil
+	}
+	if viper.GetString(context+".username") == "" {
+		return fmt.Errorf("%w: no username for context %q, use `clh config -u <username>` and `clh login`", ErrUnauthenticated, context)
+	}
+	if viper.GetString(context+".secret_key") == "" && !rootCli.PersistentFlags().Changed("secret_key-file") {
+		return fmt.Errorf("%w: no secret_key for context %q, use `clh config -k -` and `clh login`", ErrUnauthenticated, context)
+	}
+	return nil
+}
+
+// userAgent tells the Hub which clh talks to it, e.g. clh/v0.2 (linux/amd64),
+// the version is the one `clh version` prints
+func userAgent() string {
+	return fmt.Sprintf("clh/%s (%s/%s)", buildInfo().Version, runtime.GOOS, runtime.GOARCH)
+}
+
+// requestTimeout returns the --timeout for API requests, zero means no timeout
+func requestTimeout() (time.Duration, error) {
+	raw := viper.GetString("timeout")
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %v", raw, err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must not be negative", raw)
+	}
+	return timeout, nil
+}
+
+// newTransport applies --proxy (or HTTP_PROXY/HTTPS_PROXY/NO_PROXY), --insecure, --cacert
+// and insecure_endpoints of the context
+func newTransport() (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy := viper.GetString("proxy"); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	transport.TLSClientConfig = &tls.Config{}
+	if viper.GetBool("insecure") {
+		log.Warn("TLS certificate verification is disabled, the Hub can't be trusted")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	if cacert := viper.GetString("cacert"); cacert != "" {
+		pem, err := ioutil.ReadFile(cacert)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("can't parse CA certificate %s: no PEM certificates found", cacert)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	// Hosts of the context allowed without verification, the rest is verified as usual
+	insecureHosts := viper.GetStringSlice(viper.GetString("context") + ".insecure_endpoints")
+	if len(insecureHosts) == 0 || transport.TLSClientConfig.InsecureSkipVerify {
+		return transport, nil
+	}
+	for _, pattern := range insecureHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid insecure_endpoints pattern %q: %v", pattern, err)
+		}
+	}
+	log.Debug("TLS certificate verification is disabled for: ", strings.Join(insecureHosts, ", "))
+	insecure := transport.Clone()
+	insecure.TLSClientConfig.InsecureSkipVerify = true
+	return &hostsTransport{verified: transport, insecure: insecure, insecureHosts: insecureHosts}, nil
+}
+
+// hostsTransport skips TLS verification for hosts matching one of insecureHosts,
+// e.g. *.internal. Hosts are taken from request URLs as dialed, IPs included,
+// every other host goes through the verified transport untouched.
+type hostsTransport struct {
+	verified      *http.Transport
+	insecure      *http.Transport
+	insecureHosts []string
+}
+
+func (t *hostsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	for _, pattern := range t.insecureHosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			log.Debug("Skipping TLS certificate verification of ", host)
+			return t.insecure.RoundTrip(req)
+		}
+	}
+	return t.verified.RoundTrip(req)
+}
+
+// url resolves an API path against the endpoint
+func (c *apiClient) url(path string) string {
+	return c.baseURL.ResolveReference(&url.URL{Path: strings.TrimPrefix(path, "/")}).String()
+}
+
+// Do sends body as JSON and decodes a JSON response into out, both may be nil
+func (c *apiClient) Do(method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("can't encode request: %v", err)
+		}
+		payload = data
+	}
+
+	// Repeating a non idempotent request may apply it twice, so it has to be allowed explicitly
+	attempts := 1
+	if idempotentMethods[method] || c.retryUnsafe {
+		attempts += c.retries
+	}
+
+	var resp *http.Response
+	var data []byte
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		resp, data, err = c.send(method, path, payload)
+		if err == nil && resp.StatusCode < 500 || rootCtx.Err() != nil {
+			break
+		}
+		if attempt < attempts {
+			delay := retryDelay(attempt)
+			log.Debug("API request failed, retrying in ", delay)
+			select {
+			case <-time.After(delay):
+			case <-rootCtx.Done():
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && c.token != "" {
+		return fmt.Errorf("%w: session expired, run `clh login` again", ErrUnauthenticated)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		var msg struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+		if json.Unmarshal(data, &msg) == nil {
+			apiErr.Message = msg.Message
+			if apiErr.Message == "" {
+				apiErr.Message = msg.Error
+			}
+		}
+		return apiErr
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("can't decode response: %v", err)
+		}
+	}
+	return nil
+}
+
+// send makes a single request, failing over to the next endpoint when one can't be
+// reached. HTTP errors are answers of the Hub, they never trigger a failover.
+func (c *apiClient) send(method, path string, payload []byte) (*http.Response, []byte, error) {
+	var err error
+	for i, endpoint := range c.endpoints {
+		c.baseURL = endpoint
+		var resp *http.Response
+		var data []byte
+		resp, data, err = c.sendTo(method, path, payload)
+		// Canceled requests are url.Errors too
+		var urlErr *url.Error
+		if err == nil || !errors.As(err, &urlErr) || rootCtx.Err() != nil {
+			return resp, data, err
+		}
+		if i+1 < len(c.endpoints) {
+			log.Warn("Can't reach ", endpoint, ", failing over to ", c.endpoints[i+1], ": ", err)
+		}
+	}
+	return nil, nil, err
+}
+
+// sendTo makes a single request to the current endpoint and reads the whole response
+func (c *apiClient) sendTo(method, path string, payload []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(rootCtx, method, c.url(path), reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Session token from `clh login` is preferred over raw credentials
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" || c.secretKey != "" {
+		req.SetBasicAuth(c.username, c.secretKey)
+	}
+
+	log.Debug("API request: ", method, " ", req.URL)
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Trace("API request headers: ", redactHeaders(req.Header))
+		log.Trace("API request body: ", redactBody(payload))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read response: %v", err)
+	}
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Trace("API response: ", resp.Status, " ", redactHeaders(resp.Header))
+		log.Trace("API response body: ", redactBody(data))
+	}
+	return resp, data, nil
+}
+
+// redactHeaders formats headers for logs, credentials are never printed
+func redactHeaders(header http.Header) string {
+	var lines []string
+	for name, values := range header {
+		if name == "Authorization" || name == "Cookie" || name == "Set-Cookie" {
+			values = []string{maskSecret(strings.Join(values, ""))}
+		}
+		lines = append(lines, name+": "+strings.Join(values, ", "))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "; ")
+}
+
+// redactBody formats a JSON body for logs with secret fields masked at any depth,
+// objects in arrays included
+func redactBody(data []byte) string {
+	var body interface{}
+	if json.Unmarshal(data, &body) != nil {
+		return string(data)
+	}
+	switch v := body.(type) {
+	case map[string]interface{}:
+		maskSettings(v)
+	case []interface{}:
+		maskList(v)
+	}
+	redacted, err := json.Marshal(body)
+	if err != nil {
+		return string(data)
+	}
+	return string(redacted)
+}
+
+// retryDelay doubles with every attempt, up to maxRetryDelay
+func retryDelay(attempt int) time.Duration {
+	delay := baseRetryDelay << uint(attempt-1)
+	if delay <= 0 || delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
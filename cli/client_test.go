@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for fake hubs
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clh test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a server certificate for hosts, names or IPs
+func (ca *testCA) issue(t *testing.T, hosts ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// file writes the CA certificate as PEM for --cacert
+func (ca *testCA) file(t *testing.T) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestInsecureEndpoints(t *testing.T) {
+	trusted := newTestCA(t)
+	untrusted := newTestCA(t)
+	for _, tc := range []struct {
+		name string
+		ca   *testCA
+		// Names of the hub certificate and the host dialed, the hub listens on 127.0.0.1
+		certHosts []string
+		host      string
+		insecure  string
+		code      int
+	}{
+		{"listed IP", untrusted, []string{"other.example"}, "127.0.0.1", `["127.0.0.1"]`, ExitOK},
+		{"listed host", untrusted, []string{"other.example"}, "localhost", `["local*"]`, ExitOK},
+		{"valid IP", trusted, []string{"127.0.0.1"}, "127.0.0.1", `["*.internal"]`, ExitOK},
+		{"valid host", trusted, []string{"localhost"}, "localhost", `["*.internal"]`, ExitOK},
+		{"wrong SAN of IP", trusted, []string{"other.example"}, "127.0.0.1", `["*.internal"]`, ExitNetwork},
+		{"wrong SAN of host", trusted, []string{"other.example"}, "localhost", `["*.internal"]`, ExitNetwork},
+		{"wrong SAN without list", trusted, []string{"other.example"}, "127.0.0.1", `[]`, ExitNetwork},
+		{"unknown CA not listed", untrusted, []string{"localhost"}, "localhost", `["*.internal"]`, ExitNetwork},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newTLSHub(t, tc.ca.issue(t, tc.certHosts...))
+			endpoint := strings.Replace(hub.endpoint(), "127.0.0.1", tc.host, 1)
+			e := newTestEnv(t)
+			e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: local\nlocal:\n  endpoint: %s\n  insecure_endpoints: %s\n",
+				schemaVersion, endpoint, tc.insecure))
+
+			result := e.run("ping", "--retries", "0", "--cacert", trusted.file(t))
+			if result.code != tc.code {
+				t.Errorf("exit code %d, expected %d: %s", result.code, tc.code, result.stderr)
+			}
+		})
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	for _, body := range []string{
+		`{"username":"bob","secret_key":"s3cret"}`,
+		`{"keys":[{"id":"k1","secret_key":"s3cret"}]}`,
+		`[{"id":"k1","secret_key":"s3cret"},{"id":"k2","token":"tok-123"}]`,
+		`[[{"session":{"token":"tok-123"}}]]`,
+	} {
+		redacted := redactBody([]byte(body))
+		if strings.Contains(redacted, "s3cret") || strings.Contains(redacted, "tok-123") {
+			t.Errorf("secret left in %s", redacted)
+		}
+		if !strings.Contains(redacted, "********") {
+			t.Errorf("nothing masked in %s", redacted)
+		}
+	}
+	if redacted := redactBody([]byte("not json")); redacted != "not json" {
+		t.Errorf("non JSON body changed: %q", redacted)
+	}
+}
+
+// Trace logs show request and response bodies, never the secrets in them
+func TestTraceLogsNoSecrets(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("login", "-vv")
+	if !strings.Contains(result.stderr, "API response body") {
+		t.Fatalf("bodies not traced: %s", result.stderr)
+	}
+	if strings.Contains(result.stderr, hubSecretKey) || strings.Contains(result.stderr, hubToken) {
+		t.Errorf("secret in trace logs: %s", result.stderr)
+	}
+}
+
+// failFirst answers the first n requests with 503, then hands over to next
+func failFirst(n int, next http.HandlerFunc) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		failing := n > 0
+		n--
+		mu.Unlock()
+		if failing {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"message": "try again"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	login := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"token": hubToken})
+	}
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		code     int
+		requests int
+	}{
+		{"off by default", nil, ExitAPI, 1},
+		{"login is not idempotent", []string{"--retries", "2"}, ExitAPI, 1},
+		{"allowed with retry_unsafe", []string{"--retries", "2", "--retry_unsafe"}, ExitOK, 2},
+		{"one retry is enough", []string{"--retries", "1", "--retry_unsafe"}, ExitOK, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newFakeHub(t)
+			hub.handle("POST /v1/login", failFirst(1, login))
+			e := newTestEnv(t)
+			e.useHub("local", hub.endpoint())
+
+			result := e.run(append(tc.args, "login")...)
+			if result.code != tc.code {
+				t.Errorf("exit code %d, expected %d: %s", result.code, tc.code, result.stderr)
+			}
+			if got := hub.received(); len(got) != tc.requests {
+				t.Errorf("%d requests, expected %d: %v", len(got), tc.requests, got)
+			}
+		})
+	}
+}
+
+// GET is idempotent, polls are retried without --retry_unsafe
+func TestRetriesIdempotent(t *testing.T) {
+	for _, tc := range []struct {
+		retries  string
+		code     int
+		requests int
+	}{
+		{"2", ExitOK, 4},
+		{"1", ExitAPI, 3},
+	} {
+		tc := tc
+		t.Run(tc.retries, func(t *testing.T) {
+			t.Parallel()
+			hub := deployHub(t, "succeeded")
+			poll := hub.routes["GET /v1/operations/op-1"]
+			hub.handle("GET /v1/operations/op-1", failFirst(2, poll))
+			e := newTestEnv(t)
+			e.useHub("local", hub.endpoint())
+
+			result := e.run("deploy", "shop", "--wait", "--retries", tc.retries)
+			if result.code != tc.code {
+				t.Errorf("exit code %d, expected %d: %s", result.code, tc.code, result.stderr)
+			}
+			if got := hub.received(); len(got) != tc.requests {
+				t.Errorf("%d requests, expected %d: %v", len(got), tc.requests, got)
+			}
+		})
+	}
+}
+
+func TestRetriesNegative(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	if result := e.run("login", "--retries", "-1"); result.code == 0 || !strings.Contains(result.logs(), "must not be negative") {
+		t.Errorf("negative retries accepted: %d %s", result.code, result.stderr)
+	}
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Bash helpers completing context and profile names and config keys, called by cobra when it has
+// nothing to offer. Key names are filled in by bashCompletionFunc.
+const bashCompletionTemplate = `__clh_get_contexts()
+{
+    local clh_out
+    if clh_out=$(clh context list 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "$(echo "${clh_out}" | awk '/^[* ] /{print $NF}')" -- "$cur" ) )
+    fi
+}
+
+__clh_get_profiles()
+{
+    local clh_out
+    if clh_out=$(clh config profile list 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "$(echo "${clh_out}" | awk '/^[* ] /{print $NF}')" -- "$cur" ) )
+    fi
+}
+
+__clh_get_config_keys()
+{
+    local clh_out keys="%s"
+    if clh_out=$(clh context list 2>/dev/null); then
+        keys+=" $(echo "${clh_out}" | awk '/^[* ] /{ n = split("%s", k, " "); for (i = 1; i <= n; i++) print $NF "." k[i] }')"
+    fi
+    COMPREPLY=( $( compgen -W "${keys}" -- "$cur" ) )
+}
+
+__custom_func() {
+    case ${last_command} in
+        clh_use-context | clh_context_use | clh_context_delete | clh_config_set-credentials | clh_config_set-context)
+            __clh_get_contexts
+            return
+            ;;
+        clh_config_profile_use)
+            __clh_get_profiles
+            return
+            ;;
+        clh_config_get | clh_config_set | clh_config_unset)
+            # Only the key is completed, not the value
+            if [[ ${#nouns[@]} -eq 0 ]]; then
+                __clh_get_config_keys
+            fi
+            return
+            ;;
+        *)
+            ;;
+    esac
+}
+`
+
+// bashCompletionFunc fills known keys into bashCompletionTemplate
+func bashCompletionFunc() string {
+	return fmt.Sprintf(bashCompletionTemplate, strings.Join(globalKeys, " "), strings.Join(contextKeys, " "))
+}
+
+// Same completions for zsh, run before the command completion generated by cobra
+// which has no hook for them. Key names are filled in by genZshCompletion.
+const zshCompletionTemplate = `#compdef clh
+
+__clh_get_contexts()
+{
+    local -a contexts
+    contexts=(${(f)"$(clh context list 2>/dev/null | awk '/^[* ] /{print $NF}')"})
+    compadd -a contexts
+}
+
+__clh_get_profiles()
+{
+    local -a profiles
+    profiles=(${(f)"$(clh config profile list 2>/dev/null | awk '/^[* ] /{print $NF}')"})
+    compadd -a profiles
+}
+
+__clh_get_config_keys()
+{
+    local -a contexts keys
+    local context key
+    keys=(%s)
+    contexts=(${(f)"$(clh context list 2>/dev/null | awk '/^[* ] /{print $NF}')"})
+    for context in $contexts; do
+        for key in %s; do
+            keys+=("$context.$key")
+        done
+    done
+    compadd -a keys
+}
+
+# Words before the one completed, without flags
+local -a clh_args
+clh_args=(${${words[2,CURRENT-1]}:#-*})
+case "${clh_args[*]}" in
+    use-context|"context use"|"context delete"|"config set-credentials"|"config set-context")
+        __clh_get_contexts
+        return
+        ;;
+    "config profile use")
+        __clh_get_profiles
+        return
+        ;;
+    "config get"|"config set"|"config unset")
+        # Only the key is completed, not the value
+        __clh_get_config_keys
+        return
+        ;;
+esac
+
+`
+
+// genZshCompletion writes the zsh completion of clh: the dynamic part of
+// zshCompletionTemplate followed by commands as cobra generates them
+func genZshCompletion(w io.Writer) error {
+	var commands bytes.Buffer
+	if err := rootCli.GenZshCompletion(&commands); err != nil {
+		return err
+	}
+	header := "#compdef " + rootCli.Name() + "\n"
+	body := strings.TrimLeft(strings.TrimPrefix(commands.String(), header), "\n")
+	_, err := fmt.Fprintf(w, zshCompletionTemplate+"%s", strings.Join(globalKeys, " "), strings.Join(contextKeys, " "), body)
+	return err
+}
+
+var completionCli = &cobra.Command{
+	Use:       "completion <shell>",
+	Short:     "Generate shell completion script",
+	Long:      `Print completion script for bash or zsh, e.g. source <(clh completion bash) or clh completion zsh > "${fpath[1]}/_clh"`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCli.GenBashCompletion(resultWriter())
+		case "zsh":
+			return genZshCompletion(resultWriter())
+		default:
+			return fmt.Errorf("unknown shell %q, use one of: bash, zsh", args[0])
+		}
+	},
+}
+
+func init() {
+	// Completion
+
+	rootCli.BashCompletionFunction = bashCompletionFunc()
+	rootCli.AddCommand(completionCli)
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh"} {
+		t.Run(shell, func(t *testing.T) {
+			e := newTestEnv(t)
+			script := e.mustRun("completion", shell).stdout
+			for _, wanted := range []string{"__clh_get_contexts", "__clh_get_config_keys", "default_endpoint", "secret_key"} {
+				if !strings.Contains(script, wanted) {
+					t.Errorf("%s completion misses %q", shell, wanted)
+				}
+			}
+			if strings.Count(script, "#compdef") > 1 {
+				t.Errorf("%s completion has more than one #compdef line", shell)
+			}
+			path, err := exec.LookPath(shell)
+			if err != nil {
+				t.Skipf("%s not installed, syntax not checked", shell)
+			}
+			check := exec.Command(path, "-n")
+			check.Stdin = strings.NewReader(script)
+			if out, err := check.CombinedOutput(); err != nil {
+				t.Errorf("%s completion doesn't parse: %v\n%s", shell, err, out)
+			}
+		})
+	}
+}
+
+func TestCompletionUnsupportedShell(t *testing.T) {
+	e := newTestEnv(t)
+	for _, shell := range []string{"fish", "powershell"} {
+		if result := e.run("completion", shell); result.code == 0 {
+			t.Errorf("completion for %s succeeded", shell)
+		}
+	}
+}
@@ -0,0 +1,873 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Keys holding secrets, masked on output
+var secretKeys = []string{"secret_key", "token"}
+
+// Top level keys which are not contexts
+var globalKeys = []string{"context", "default_endpoint", "log_level", "log_format", "log_color", "log_file", "log_stdout", "output", "timeout", "proxy", "insecure", "cacert", "retries", "retry_unsafe", "update_url", "backups"}
+
+// Starter config written by `clh config init`
+const starterConfig = `# clh config, see https://cloudlethub.com/docs
+
+# Format of this file, see ` + "`clh config migrate`" + `
+schema_version: 1
+
+# Context used when --context is not given
+context: default
+
+# Every other top level section is a context
+default:
+  # CloudletHub API address
+  endpoint: https://api.cloudlethub.com/
+  # Credentials, see ` + "`clh config -u <username> -k <secret_key>`" + `
+  username: ""
+  secret_key: ""
+`
+
+var configInitCli = &cobra.Command{
+	Use:   "init",
+	Short: "Create a starter config",
+	Long:  "Write a commented starter config with a default context, existing config requires --force",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if noConfig() {
+			return errNoConfig
+		}
+		fileName := viper.GetString("config")
+		if fileName == "" {
+			return errNoConfigFile
+		}
+		if fileName == stdinConfigName {
+			return errConfigStdin
+		}
+		if err := checkWritable(); err != nil {
+			return err
+		}
+		if _, err := os.Stat(fileName); err == nil {
+			if force, _ := cmd.Flags().GetBool("force"); !force {
+				return fmt.Errorf("config %s already exists, use --force to overwrite it", fileName)
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+			return fmt.Errorf("%w %s: %v", ErrConfigWrite, fileName, err)
+		}
+		if err := backupConfig(fileName); err != nil {
+			return err
+		}
+		write := func(path string) error {
+			return ioutil.WriteFile(path, []byte(starterConfig), 0600)
+		}
+		// Comments only survive in yaml, other formats get the same settings
+		if configType(fileName) != "yaml" {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(starterConfig)); err != nil {
+				return err
+			}
+			write = v.WriteConfigAs
+		}
+		if err := writeFileAtomic(fileName, write); err != nil {
+			return err
+		}
+
+		printResult("Config created at " + fileName)
+		return nil
+	},
+}
+
+var configGetCli = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a config value",
+	Long:  "Print the effective value of a config key such as default.endpoint",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := strings.ToLower(args[0])
+		value := viper.Get(key)
+		if value == nil || value == "" {
+			return fmt.Errorf("key %q is not set", key)
+		}
+		if _, ok := value.(map[string]interface{}); ok {
+			return fmt.Errorf("key %q is a section, not a value", key)
+		}
+
+		showSecrets, _ := cmd.Flags().GetBool("show-secrets")
+		if isSecretKey(key) && !showSecrets {
+			value = maskSecret(viper.GetString(key))
+		}
+		printResult(value)
+		return nil
+	},
+}
+
+var configSetCli = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a config value",
+	Long:  "Set a config key such as default.endpoint and save the config",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := strings.ToLower(args[0])
+		if err := validateKey(key); err != nil {
+			return err
+		}
+		// A typo like defualt.endpoint would silently add a context
+		create, _ := cmd.Flags().GetBool("create-context")
+		if !create && strings.Contains(key, ".") {
+			if err := checkContextExists(strings.Split(key, ".")[0]); err != nil {
+				return err
+			}
+		}
+
+		if strings.HasSuffix(key, ".endpoint") {
+			if err := validateEndpoint(args[1]); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case key == "context":
+			// Same as `clh context use` without prefixes, a typo doesn't switch to nowhere
+			name := strings.ToLower(args[1])
+			if err := checkSwitch(name, create, "--create-context"); err != nil {
+				return err
+			}
+			setDefaultContext(name)
+		case strings.HasSuffix(key, ".endpoints"):
+			// Saved as a list, given as space separated endpoints
+			endpoints := strings.Fields(args[1])
+			for _, endpoint := range endpoints {
+				if err := validateEndpoint(endpoint); err != nil {
+					return err
+				}
+			}
+			setConfig(key, endpoints)
+		case strings.HasSuffix(key, ".insecure_endpoints"):
+			setConfig(key, strings.Fields(args[1]))
+		default:
+			value, err := parseValue(key, args[1])
+			if err != nil {
+				return err
+			}
+			setConfig(key, value)
+		}
+		return saveConfig()
+	},
+}
+
+var configUnsetCli = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a config value",
+	Long:  "Remove a config key such as default.username and save the config",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := strings.ToLower(args[0])
+		if value := viper.Get(key); value == nil || value == "" {
+			log.Warn("Key is not set, nothing to do: ", key)
+			return nil
+		}
+
+		unsetConfig(key)
+		return saveConfig()
+	},
+}
+
+var configViewCli = &cobra.Command{
+	Use:   "view",
+	Short: "Print the whole effective config",
+	Long:  "Print all settings merged from env, config files and flags as YAML",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settings := viper.AllSettings()
+		if showSecrets, _ := cmd.Flags().GetBool("show-secrets"); !showSecrets {
+			maskSettings(settings)
+		}
+
+		data, err := yaml.Marshal(settings)
+		if err != nil {
+			return fmt.Errorf("can't render config: %v", err)
+		}
+
+		printResult("# Merge order, later overrides earlier:")
+		for _, path := range configFiles() {
+			if path == stdinConfigName {
+				printResult("#   stdin")
+			} else if inSlice(path, mergedConfigs) {
+				printResult("#   " + path)
+			} else {
+				printResult("#   " + path + " (not found)")
+			}
+		}
+		printResult("#   CLH_* environment variables")
+		printResult("#   command line flags")
+		fmt.Fprint(resultWriter(), string(data))
+		return nil
+	},
+}
+
+var configExportCli = &cobra.Command{
+	Use:   "export",
+	Short: "Print the config without secrets",
+	Long:  "Print settings of all config files without secret keys and tokens, safe to share or commit",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Env, flags and defaults are not part of a sharable config
+		settings := fileConfig.AllSettings()
+		delete(settings, "config")
+		stripSecrets(settings)
+
+		fileName, _ := cmd.Flags().GetString("file")
+		if fileName == "" {
+			data, err := yaml.Marshal(settings)
+			if err != nil {
+				return fmt.Errorf("can't render config: %v", err)
+			}
+			fmt.Fprint(resultWriter(), string(data))
+			return nil
+		}
+
+		v := viper.New()
+		v.MergeConfigMap(settings)
+		if err := writeFileAtomic(fileName, v.WriteConfigAs); err != nil {
+			return err
+		}
+		printResult("Config exported to " + fileName)
+		return nil
+	},
+}
+
+var configImportCli = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Merge contexts from a shared config",
+	Long:  "Add contexts of another yaml, json or toml config, existing values are kept unless --overwrite",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		imported, err := readConfigFile(args[0])
+		if err != nil {
+			return err
+		}
+		if len(imported) == 0 {
+			return fmt.Errorf("%w: %s", ErrConfigNotFound, args[0])
+		}
+
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		changed := 0
+		for _, name := range sortedKeys(imported) {
+			section, ok := imported[name].(map[string]interface{})
+			if !ok || !isContext(section) || inSlice(name, globalKeys) {
+				continue
+			}
+			for _, key := range sortedKeys(section) {
+				path := name + "." + key
+				if err := validateKey(path); err != nil {
+					log.Warn("Skipping ", err)
+					continue
+				}
+				value := section[key]
+				if fileConfig.IsSet(path) {
+					if reflect.DeepEqual(fileConfig.Get(path), value) {
+						continue
+					}
+					if !overwrite {
+						printResult("Kept " + path + ", use --overwrite to replace it")
+						continue
+					}
+					printResult("Replaced " + path)
+				} else {
+					printResult("Added " + path)
+				}
+				setConfig(path, value)
+				changed++
+			}
+		}
+
+		if changed == 0 {
+			printResult("Nothing to import")
+			return nil
+		}
+		return saveConfig()
+	},
+}
+
+var configPathCli = &cobra.Command{
+	Use:   "path",
+	Short: "Print the config file path",
+	Long:  "Print the config file clh saves to, --all prints every searched path in merge order",
+	Run: func(cmd *cobra.Command, args []string) {
+		fileName := viper.GetString("config")
+		if all, _ := cmd.Flags().GetBool("all"); !all {
+			printResult(fileName)
+			return
+		}
+
+		files := configFiles()
+		if !inSlice(fileName, files) {
+			files = append(files, fileName)
+		}
+		for _, path := range files {
+			printResult(path)
+		}
+	},
+}
+
+var configEnvCli = &cobra.Command{
+	Use:   "env",
+	Short: "Print environment variables overriding settings",
+	Long:  "Print the CLH_ environment variable of every setting of the current context, set ones are marked",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		var keys []string
+		keys = append(keys, globalKeys...)
+		for _, key := range contextKeys {
+			keys = append(keys, context+"."+key)
+		}
+
+		info := envInfo{}
+		for _, key := range keys {
+			_, set := os.LookupEnv(envName(key))
+			info.Vars = append(info.Vars, envVar{Key: key, Env: envName(key), Set: set})
+		}
+		// Read before any config file, so they are no settings
+		for _, flag := range []string{"config", "config-dir", "config-profile", "no-config"} {
+			_, set := os.LookupEnv(envName(flag))
+			info.Vars = append(info.Vars, envVar{Key: "--" + flag, Env: envName(flag), Set: set})
+		}
+		return render(info)
+	},
+}
+
+type envVar struct {
+	Key string `json:"key" yaml:"key"`
+	Env string `json:"env" yaml:"env"`
+	Set bool   `json:"set" yaml:"set"`
+}
+
+type envInfo struct {
+	Vars []envVar `json:"vars" yaml:"vars"`
+}
+
+func (e envInfo) rows() [][]string {
+	rows := [][]string{{"KEY", "ENV", "SET"}}
+	for _, v := range e.Vars {
+		set := ""
+		if v.Set {
+			set = "*"
+		}
+		rows = append(rows, []string{v.Key, v.Env, set})
+	}
+	return rows
+}
+
+var configTestCli = &cobra.Command{
+	Use:   "test",
+	Short: "Test credentials without saving them",
+	Long:  "Log in with --endpoint, --username and --secret_key, or the current context values, nothing is written to disk",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		if err := readSecretKey(context); err != nil {
+			return err
+		}
+		endpoints := contextEndpoints(context)
+		username := viper.GetString(context + ".username")
+		secretKey := viper.GetString(context + ".secret_key")
+		if username == "" || secretKey == "" {
+			return errors.New("username and secret_key are required, use -u and -k")
+		}
+
+		if _, err := requestToken(endpoints, username, secretKey); err != nil {
+			return err
+		}
+		printResult("Credentials of " + username + " @ " + strings.Join(endpoints, ", ") + " are valid")
+		return nil
+	},
+}
+
+var configSetCredentialsCli = &cobra.Command{
+	Use:   "set-credentials <context>",
+	Short: "Set credentials of a context",
+	Long:  "Save --username and --secret_key of any context in one go, a new context requires --create",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := strings.ToLower(args[0])
+		if err := validateContextName(name); err != nil {
+			return err
+		}
+		if !hasContext(name) {
+			if create, _ := cmd.Flags().GetBool("create"); !create {
+				return fmt.Errorf("%w: %q, use --create to add it", ErrContextMissing, name)
+			}
+		}
+
+		changed, err := setCredentials(name)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return errors.New("nothing to set, use --username and/or --secret_key")
+		}
+		return saveConfig()
+	},
+}
+
+var configSetContextCli = &cobra.Command{
+	Use:   "set-context <name>",
+	Short: "Create or update a context",
+	Long:  "Save --endpoint, --username and --secret_key of a context at once, only given flags are changed",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := strings.ToLower(args[0])
+		if err := validateContextName(name); err != nil {
+			return err
+		}
+
+		flags := rootCli.PersistentFlags()
+		changed := flags.Changed("endpoint")
+		if changed {
+			endpoint, _ := flags.GetString("endpoint")
+			if err := validateEndpoint(endpoint); err != nil {
+				return err
+			}
+			setConfig(name+".endpoint", endpoint)
+		}
+		credentials, err := setCredentials(name)
+		if err != nil {
+			return err
+		}
+		if !changed && !credentials {
+			return errors.New("nothing to set, use --endpoint, --username and/or --secret_key")
+		}
+		return saveConfig()
+	},
+}
+
+var configValidateCli = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the current context",
+	Long:  "Check the current context has everything needed to talk to the Hub, --strict also checks config files for unknown keys and wrong types",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateContext(); err != nil {
+			return err
+		}
+
+		if strict, _ := cmd.Flags().GetBool("strict"); strict {
+			failed := 0
+			for _, fileName := range mergedConfigs {
+				settings, err := readConfigFile(fileName)
+				if err != nil {
+					return err
+				}
+				problems, err := validateConfigSchema(settings)
+				if err != nil {
+					return err
+				}
+				for _, problem := range problems {
+					printResult(fileName + ": " + problem)
+				}
+				failed += len(problems)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%w: config doesn't match the schema, %d problems found", ErrConfigInvalid, failed)
+			}
+		}
+
+		printResult("Context " + viper.GetString("context") + " is valid")
+		return nil
+	},
+}
+
+func init() {
+	// Config Init
+
+	configInitCli.Flags().BoolP("force", "f", false, "Overwrite existing config")
+	configCli.AddCommand(configInitCli)
+
+	// Config Get
+
+	configGetCli.Flags().BoolP("show-secrets", "", false, "Print secret values as is")
+	configCli.AddCommand(configGetCli)
+
+	// Config Set
+
+	configSetCli.Flags().BoolP("create-context", "", false, "Create the context of the key, or the one set as context, if it doesn't exist")
+	configCli.AddCommand(configSetCli)
+
+	// Config Unset
+
+	configCli.AddCommand(configUnsetCli)
+
+	// Config View
+
+	configViewCli.Flags().BoolP("show-secrets", "", false, "Print secret values as is")
+	configCli.AddCommand(configViewCli)
+
+	// Config Export
+
+	configExportCli.Flags().StringP("file", "f", "", "Write to a yaml, json or toml file instead of stdout")
+	configCli.AddCommand(configExportCli)
+
+	// Config Import
+
+	configImportCli.Flags().BoolP("overwrite", "", false, "Replace existing values, secrets included")
+	configCli.AddCommand(configImportCli)
+
+	// Config Path
+
+	configPathCli.Flags().BoolP("all", "a", false, "Print all config search paths")
+	configCli.AddCommand(configPathCli)
+
+	// Config Env
+
+	configCli.AddCommand(configEnvCli)
+
+	// Config Test
+
+	configCli.AddCommand(configTestCli)
+
+	// Config Set Credentials
+
+	configSetCredentialsCli.Flags().BoolP("create", "", false, "Create the context if it doesn't exist")
+	configCli.AddCommand(configSetCredentialsCli)
+
+	// Config Set Context
+
+	configCli.AddCommand(configSetContextCli)
+
+	// Config Validate
+
+	configValidateCli.Flags().BoolP("strict", "", false, "Check config files against the config schema")
+	configCli.AddCommand(configValidateCli)
+}
+
+// validateContextName checks a name can hold a context section
+func validateContextName(name string) error {
+	if name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid context name %q", name)
+	}
+	if inSlice(name, globalKeys) {
+		return fmt.Errorf("%q is reserved and can't be a context name", name)
+	}
+	return nil
+}
+
+// setCredentials records --username and --secret_key (or --secret_key-file) for
+// any context, flags are only bound to the current one. It tells if any was given.
+func setCredentials(context string) (bool, error) {
+	flags := rootCli.PersistentFlags()
+	setUsername := flags.Changed("username")
+	setSecretKey := flags.Changed("secret_key") || flags.Changed("secret_key-file")
+
+	if setUsername {
+		username, _ := flags.GetString("username")
+		setConfig(context+".username", username)
+	}
+	if setSecretKey {
+		if err := readSecretKey(context); err != nil {
+			return false, err
+		}
+		secretKey, _ := flags.GetString("secret_key")
+		if secretKey == "-" || flags.Changed("secret_key-file") {
+			secretKey = viper.GetString(context + ".secret_key")
+		}
+		setConfig(context+".secret_key", secretKey)
+	}
+	return setUsername || setSecretKey, nil
+}
+
+// validateKey checks a key is either a global one or a known key under a context
+func validateKey(key string) error {
+	path := strings.Split(key, ".")
+	switch len(path) {
+	case 1:
+		if inSlice(path[0], globalKeys) {
+			return nil
+		}
+		return fmt.Errorf("%w %q, known top level keys are: %s", ErrInvalidKey, key, strings.Join(globalKeys, ", "))
+	case 2:
+		if inSlice(path[0], globalKeys) {
+			return fmt.Errorf("%w %q: %q is not a context, it holds a value", ErrInvalidKey, key, path[0])
+		}
+		if inSlice(path[1], contextKeys) {
+			return nil
+		}
+		return fmt.Errorf("%w %q, known context keys are: %s", ErrInvalidKey, key, strings.Join(contextKeys, ", "))
+	default:
+		return fmt.Errorf("%w %q, use <key> or <context>.<key>", ErrInvalidKey, key)
+	}
+}
+
+// parseValue converts a value given as text to the type of key in the config schema,
+// so e.g. `retries: 3` is saved as a number. Other keys are kept as text. Whatever
+// is returned passes the schema, e.g. output is one of its formats.
+func parseValue(key, text string) (interface{}, error) {
+	typ, err := keyType(key)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{} = text
+	switch typ {
+	case "boolean":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be true or false, got %q", ErrConfigInvalid, key, text)
+		}
+		value = b
+	case "integer":
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be an integer, got %q", ErrConfigInvalid, key, text)
+		}
+		value = i
+	}
+	// Durations are kept as text like 1m, yet have to parse
+	if key == "timeout" {
+		if _, err := time.ParseDuration(text); err != nil {
+			return nil, fmt.Errorf("%w: %s must be a duration like 30s or 1m, got %q", ErrConfigInvalid, key, text)
+		}
+	}
+
+	problems, err := validateKeyValue(key, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrConfigInvalid, strings.Join(problems, ", "))
+	}
+	return value, nil
+}
+
+// checkContextExists fails for a context which is neither configured nor the current one
+func checkContextExists(name string) error {
+	names := contextNames()
+	if inSlice(name, names) || name == viper.GetString("context") {
+		return nil
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%w: %q, there are no contexts yet, use --create-context to add it", ErrContextMissing, name)
+	}
+	return fmt.Errorf("%w: %q, known contexts are: %s, use --create-context to add it", ErrContextMissing, name, strings.Join(names, ", "))
+}
+
+// readSecretKey reads the secret key from --secret_key-file or stdin for --secret_key -,
+// so it never shows up in argv or shell history. It is used for this run only,
+// `clh config` saves it.
+func readSecretKey(context string) error {
+	// Stdin can only be read once, later calls in the run get the same key
+	if secretKey, ok := secretKeysRead[context]; ok {
+		viper.Set(context+".secret_key", secretKey)
+		return nil
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	flags := rootCli.PersistentFlags()
+	// The flag itself, it is only bound to the current context
+	flag, _ := flags.GetString("secret_key")
+	fromStdin := flags.Changed("secret_key") && flag == "-"
+	if fromStdin && viper.GetString("config") == stdinConfigName {
+		return errors.New("stdin is already read by --config -, use --secret_key-file")
+	}
+	switch file, _ := flags.GetString("secret_key-file"); {
+	case file != "" && fromStdin:
+		return errors.New("use either --secret_key - or --secret_key-file, not both")
+	case file != "":
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return fmt.Errorf("can't read secret key file: %v", err)
+		}
+	case fromStdin:
+		if data, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("can't read secret key: %v", err)
+		}
+	default:
+		return nil
+	}
+
+	secretKey := strings.TrimSpace(string(data))
+	if secretKey == "" {
+		return errors.New("secret key is empty")
+	}
+	viper.Set(context+".secret_key", secretKey)
+	secretKeysRead[context] = secretKey
+	return nil
+}
+
+// Secret keys read by readSecretKey in this run, by context
+var secretKeysRead = map[string]string{}
+
+// promptCredentials asks for username and secret key missing in the context,
+// nothing is asked unless stdin is a terminal
+func promptCredentials(context string) error {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil
+	}
+
+	if viper.GetString(context+".username") == "" {
+		fmt.Print("Username: ")
+		username, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("can't read username: %v", err)
+		}
+		setConfig(context+".username", strings.TrimSpace(username))
+	}
+
+	if viper.GetString(context+".secret_key") == "" {
+		fmt.Print("Secret Key: ")
+		secretKey, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return fmt.Errorf("can't read secret key: %v", err)
+		}
+		setConfig(context+".secret_key", strings.TrimSpace(string(secretKey)))
+	}
+
+	return nil
+}
+
+// validateContext checks the current context has a usable endpoint
+func validateContext() error {
+	context := viper.GetString("context")
+	for _, endpoint := range contextEndpoints(context) {
+		if endpoint == "" {
+			return fmt.Errorf("%w: context %q has no endpoint, use `clh config -e <endpoint>`", ErrInvalidEndpoint, context)
+		}
+		if err := validateEndpoint(endpoint); err != nil {
+			return fmt.Errorf("context %q: %w", context, err)
+		}
+	}
+	return nil
+}
+
+// contextEndpoints returns endpoints of a context in failover order,
+// endpoints replaces endpoint unless --endpoint is given
+func contextEndpoints(context string) []string {
+	if !rootCli.PersistentFlags().Changed("endpoint") {
+		if endpoints := viper.GetStringSlice(context + ".endpoints"); len(endpoints) > 0 {
+			return endpoints
+		}
+	}
+	return []string{viper.GetString(context + ".endpoint")}
+}
+
+// validateEndpoint checks endpoint is an absolute http(s) URL
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidEndpoint, endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w %q: scheme must be http or https", ErrInvalidEndpoint, endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w %q: host is missing", ErrInvalidEndpoint, endpoint)
+	}
+	return nil
+}
+
+func inSlice(value string, slice []string) bool {
+	for _, v := range slice {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// configFiles lists standard and custom config files in merge order
+func configFiles() []string {
+	var files []string
+	for _, path := range configPaths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if !inSlice(path, files) {
+			files = append(files, path)
+		}
+	}
+	for _, path := range mergedConfigs {
+		if !inSlice(path, files) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// hasSecrets tells if any secret is set, at any depth
+func hasSecrets(settings map[string]interface{}) bool {
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if hasSecrets(v) {
+				return true
+			}
+		case string:
+			if v != "" && inSlice(key, secretKeys) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripSecrets removes secrets in place, at any depth
+func stripSecrets(settings map[string]interface{}) {
+	for key, value := range settings {
+		if inSlice(key, secretKeys) {
+			delete(settings, key)
+		} else if sub, ok := value.(map[string]interface{}); ok {
+			stripSecrets(sub)
+		}
+	}
+}
+
+// maskSettings masks secrets in place, at any depth
+func maskSettings(settings map[string]interface{}) {
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			maskSettings(v)
+		case []interface{}:
+			maskList(v)
+		case string:
+			if inSlice(key, secretKeys) {
+				settings[key] = maskSecret(v)
+			}
+		}
+	}
+}
+
+// maskList masks secrets of settings found in a list, at any depth
+func maskList(list []interface{}) {
+	for _, value := range list {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			maskSettings(v)
+		case []interface{}:
+			maskList(v)
+		}
+	}
+}
+
+// isSecretKey reports whether a dotted key path points to a secret
+func isSecretKey(key string) bool {
+	path := strings.Split(strings.ToLower(key), ".")
+	return inSlice(path[len(path)-1], secretKeys)
+}
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigSetTypedValues(t *testing.T) {
+	e := newTestEnv(t)
+	for _, args := range [][]string{
+		{"insecure", "true"},
+		{"retries", "3"},
+		{"timeout", "1m"},
+		{"default.username", "007"},
+	} {
+		e.mustRun(append([]string{"config", "set"}, args...)...)
+	}
+
+	config := e.readConfig()
+	for _, wanted := range []string{"insecure: true\n", "retries: 3\n", "timeout: 1m\n", `username: "007"`} {
+		if !strings.Contains(config, wanted) {
+			t.Errorf("config misses %q:\n%s", wanted, config)
+		}
+	}
+}
+
+func TestConfigSetInvalidValue(t *testing.T) {
+	for _, args := range [][]string{
+		{"insecure", "yes please"},
+		{"retries", "three"},
+		{"timeout", "soon"},
+		{"output", "xml"},
+		{"default.output", "xml"},
+		{"log_format", "xml"},
+		{"log_level", "loud"},
+		{"log_color", "sometimes"},
+	} {
+		e := newTestEnv(t)
+		result := e.run(append([]string{"config", "set"}, args...)...)
+		if result.code != ExitConfig {
+			t.Errorf("config set %s exited with %d, expected %d: %s", strings.Join(args, " "), result.code, ExitConfig, result.stderr)
+		}
+		if _, err := os.Stat(e.configFile()); !os.IsNotExist(err) {
+			t.Errorf("config set %s saved an invalid value", strings.Join(args, " "))
+		}
+	}
+}
+
+// Whatever config set writes has to pass the schema it is checked against
+func TestConfigSetPassesStrictValidation(t *testing.T) {
+	e := newTestEnv(t)
+	for _, args := range [][]string{
+		{"insecure", "true"},
+		{"retries", "3"},
+		{"retry_unsafe", "false"},
+		{"log_stdout", "1"},
+		{"log_level", "debug"},
+		{"log_format", "json"},
+		{"log_color", "never"},
+		{"backups", "2"},
+		{"timeout", "30s"},
+		{"output", "json"},
+		{"default.endpoints", "https://hub1.example.com/ https://hub2.example.com/"},
+		{"default.username", "bob"},
+	} {
+		e.mustRun(append([]string{"config", "set"}, args...)...)
+	}
+
+	if result := e.run("config", "validate", "--strict"); result.code != 0 {
+		t.Errorf("config set wrote a config failing its schema: %s%s\n%s", result.stdout, result.stderr, e.readConfig())
+	}
+}
+
+func TestContextFromEnv(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: default\ndefault:\n  endpoint: %s\nprod:\n  endpoint: %s\n",
+		schemaVersion, closedEndpoint(t), hub.endpoint()))
+	// Context names are case insensitive, env included
+	e.env = append(e.env, "CLH_CONTEXT=Prod")
+
+	if current := e.mustRun("context", "current").stdout; current != "prod\n" {
+		t.Errorf("current context is %q, expected prod", current)
+	}
+	result := e.mustRun("ping", "-o", "json")
+	if !strings.Contains(result.stdout, hub.endpoint()+healthPath) {
+		t.Errorf("endpoint of prod not used: %q", result.stdout)
+	}
+}
+
+func TestContextFromEnvOnly(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.env = append(e.env, "CLH_CONTEXT=ci", "CLH_CI_ENDPOINT="+hub.endpoint())
+
+	result := e.mustRun("ping", "-o", "json")
+	if !strings.Contains(result.stdout, hub.endpoint()+healthPath) {
+		t.Errorf("endpoint of ci from env not used: %q", result.stdout)
+	}
+}
+
+func TestConfigValidateStrictFailure(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\nretries: many\ndefault:\n  username: bob\n", schemaVersion))
+
+	if result := e.run("config", "validate", "--strict"); result.code != ExitConfig {
+		t.Errorf("config validate --strict exited with %d, expected %d: %s%s", result.code, ExitConfig, result.stdout, result.stderr)
+	}
+}
+
+func TestConfigSetContext(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		context string
+		code    int
+		message string
+	}{
+		{"existing", []string{"Prod"}, "prod", 0, ""},
+		{"typo", []string{"prdo"}, "dev", ExitConfig, `did you mean "prod"? Switch anyway with --create-context`},
+		{"missing", []string{"qa"}, "dev", ExitConfig, "switch anyway with --create-context"},
+		{"created", []string{"qa", "--create-context"}, "qa", 0, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  username: a\nprod:\n  username: b\n", schemaVersion))
+
+			result := e.run(append([]string{"config", "set", "context"}, tc.args...)...)
+			if result.code != tc.code || !strings.Contains(result.logs(), tc.message) {
+				t.Errorf("exit code %d, expected %d with %q: %s", result.code, tc.code, tc.message, result.logs())
+			}
+			if current := e.mustRun("context", "current").stdout; current != tc.context+"\n" {
+				t.Errorf("current context is %q, expected %q", current, tc.context)
+			}
+		})
+	}
+}
+
+func TestConfigExport(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  endpoint: https://dev.example.com/\n  username: bob\n  secret_key: s3cret\n  token: tok-123\n", schemaVersion))
+	e.env = append(e.env, "CLH_DEV_USERNAME=fromenv")
+
+	exported := e.mustRun("config", "export").stdout
+	fileName := filepath.Join(e.home, "shared.yaml")
+	e.mustRun("config", "export", "--file", fileName)
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, config := range map[string]string{"stdout": exported, "--file": string(data)} {
+		for _, wanted := range []string{"endpoint: https://dev.example.com/", "username: bob"} {
+			if !strings.Contains(config, wanted) {
+				t.Errorf("export to %s misses %q:\n%s", name, wanted, config)
+			}
+		}
+		for _, unwanted := range []string{"secret_key", "s3cret", "token", "fromenv"} {
+			if strings.Contains(config, unwanted) {
+				t.Errorf("export to %s holds %q:\n%s", name, unwanted, config)
+			}
+		}
+	}
+}
+
+func TestConfigImport(t *testing.T) {
+	shared := fmt.Sprintf("schema_version: %d\ncontext: prod\ndev:\n  endpoint: https://shared.example.com/\n  secret_key: theirs\nprod:\n  endpoint: https://prod.example.com/\n", schemaVersion)
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		messages []string
+		wanted   []string
+		unwanted []string
+	}{
+		{"merged", nil,
+			[]string{"Added prod.endpoint", "Kept dev.endpoint, use --overwrite to replace it", "Kept dev.secret_key"},
+			[]string{"context: dev", "endpoint: https://dev.example.com/", "secret_key: mine", "endpoint: https://prod.example.com/"},
+			[]string{"shared.example.com", "theirs"}},
+		{"overwritten", []string{"--overwrite"},
+			[]string{"Added prod.endpoint", "Replaced dev.endpoint", "Replaced dev.secret_key"},
+			[]string{"context: dev", "endpoint: https://shared.example.com/", "secret_key: theirs", "endpoint: https://prod.example.com/"},
+			[]string{"dev.example.com", "mine"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  endpoint: https://dev.example.com/\n  secret_key: mine\n", schemaVersion))
+			fileName := filepath.Join(e.home, "shared.yaml")
+			if err := ioutil.WriteFile(fileName, []byte(shared), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			result := e.mustRun(append([]string{"config", "import", fileName}, tc.args...)...)
+			for _, message := range tc.messages {
+				if !strings.Contains(result.stdout, message) {
+					t.Errorf("import doesn't report %q: %q", message, result.stdout)
+				}
+			}
+			config := e.readConfig()
+			for _, wanted := range tc.wanted {
+				if !strings.Contains(config, wanted) {
+					t.Errorf("config misses %q after import:\n%s", wanted, config)
+				}
+			}
+			for _, unwanted := range tc.unwanted {
+				if strings.Contains(config, unwanted) {
+					t.Errorf("config holds %q after import:\n%s", unwanted, config)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Keys which may be stored under a context
+var contextKeys = []string{"endpoint", "username", "secret_key", "token", "output", "credential_helper", "endpoints", "insecure_endpoints"}
+
+var contextCli = &cobra.Command{
+	Use:   "context",
+	Short: "Manage clh contexts",
+	Long:  "List, inspect, switch, rename and delete contexts stored in the clh config",
+	Args:  subcommandArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var contextUseCli = &cobra.Command{
+	Use:     "use [name]",
+	Aliases: []string{"set-default"},
+	Short:   "Switch to another context and save it as default",
+	Long:    "Save provided context, or the one given by --context, as default",
+	Args:    cobra.MaximumNArgs(1),
+	RunE:    useContext,
+}
+
+var contextListCli = &cobra.Command{
+	Use:   "list",
+	Short: "List configured contexts",
+	Long:  "List all contexts found in the config, current one is marked with an asterisk",
+	Run: func(cmd *cobra.Command, args []string) {
+		names := contextNames()
+		if len(names) == 0 {
+			printResult("No contexts configured yet, use `clh config` to create one")
+			return
+		}
+
+		current := viper.GetString("context")
+		for _, name := range names {
+			if name == current {
+				printResult("* " + name)
+			} else {
+				printResult("  " + name)
+			}
+		}
+	},
+}
+
+var contextDeleteCli = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a context from the config",
+	Long:  "Remove the whole context section from the config file, current context requires --force",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := strings.ToLower(args[0])
+		if !hasContext(name) {
+			return fmt.Errorf("%w: %q", ErrContextMissing, name)
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		if name == viper.GetString("context") {
+			if !force {
+				return fmt.Errorf("context %q is the current one, use --force to delete it anyway", name)
+			}
+			log.Warn("Deleting current context, switch to another one with `clh context use`")
+		}
+
+		unsetConfig(name)
+		return saveConfig()
+	},
+}
+
+var contextRenameCli = &cobra.Command{
+	Use:   "rename <old> <new>",
+	Short: "Rename a context",
+	Long:  "Move all settings of a context under a new name, existing context requires --force",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldName, newName := strings.ToLower(args[0]), strings.ToLower(args[1])
+		if !hasContext(oldName) {
+			return fmt.Errorf("%w: %q", ErrContextMissing, oldName)
+		}
+		if oldName == newName {
+			return nil
+		}
+		if inSlice(newName, globalKeys) {
+			return fmt.Errorf("%q is reserved and can't be a context name", newName)
+		}
+
+		// Only what config files hold is moved, not env, flags or defaults
+		settings := fileConfig.AllSettings()
+		section, _ := settings[oldName].(map[string]interface{})
+		if existing, ok := settings[newName].(map[string]interface{}); ok {
+			if force, _ := cmd.Flags().GetBool("force"); !force {
+				return fmt.Errorf("context %q already exists, use --force to overwrite it", newName)
+			}
+			for key := range existing {
+				if _, ok := section[key]; !ok {
+					unsetConfig(newName + "." + key)
+				}
+			}
+		}
+
+		for key, value := range section {
+			setConfig(newName+"."+key, value)
+		}
+		unsetConfig(oldName)
+
+		if fileConfig.GetString("context") == oldName {
+			setDefaultContext(newName)
+		} else if viper.GetString("context") == oldName {
+			viper.Set("context", newName)
+		}
+		return saveConfig()
+	},
+}
+
+var contextCurrentCli = &cobra.Command{
+	Use:   "current",
+	Short: "Print the current context name",
+	Long:  "Print only the name of the current context, for scripts",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		if context == "" {
+			return errors.New("no current context, use `clh context use <name>`")
+		}
+		printResult(context)
+		return nil
+	},
+}
+
+var contextShowCli = &cobra.Command{
+	Use:   "show",
+	Short: "Show settings of the current context",
+	Long:  "Print effective settings of the current context after merging env, config files and flags",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		return render(contextInfo{
+			Context:   context,
+			Endpoint:  strings.Join(contextEndpoints(context), ", "),
+			Username:  viper.GetString(context + ".username"),
+			SecretKey: maskSecret(viper.GetString(context + ".secret_key")),
+			Config:    configFileUsed(),
+		})
+	},
+}
+
+type contextInfo struct {
+	Context   string `json:"context" yaml:"context"`
+	Endpoint  string `json:"endpoint" yaml:"endpoint"`
+	Username  string `json:"username" yaml:"username"`
+	SecretKey string `json:"secret_key" yaml:"secret_key"`
+	Config    string `json:"config" yaml:"config"`
+}
+
+func (c contextInfo) rows() [][]string {
+	return [][]string{
+		{"context:", c.Context},
+		{"endpoint:", c.Endpoint},
+		{"username:", c.Username},
+		{"secret_key:", c.SecretKey},
+		{"config:", c.Config},
+	}
+}
+
+func init() {
+	// Context
+
+	contextUseCli.Flags().BoolP("create", "", false, "Switch even if the context is not configured yet")
+	contextCli.AddCommand(contextUseCli)
+
+	contextCli.AddCommand(contextListCli)
+
+	contextCli.AddCommand(contextCurrentCli)
+
+	contextCli.AddCommand(contextShowCli)
+
+	contextDeleteCli.Flags().BoolP("force", "f", false, "Delete even if it is the current context")
+	contextCli.AddCommand(contextDeleteCli)
+
+	contextRenameCli.Flags().BoolP("force", "f", false, "Overwrite the new context if it exists")
+	contextCli.AddCommand(contextRenameCli)
+
+	rootCli.AddCommand(contextCli)
+}
+
+// useContext saves the context given as argument or by --context as default,
+// a unique prefix of a context name is enough. A context missing in the config requires --create.
+func useContext(cmd *cobra.Command, args []string) error {
+	name := viper.GetString("context")
+	if len(args) > 0 {
+		name = strings.ToLower(args[0])
+	}
+
+	create, _ := cmd.Flags().GetBool("create")
+	if !hasContext(name) && !create && len(args) > 0 {
+		match, err := matchContext(name)
+		if err != nil {
+			return err
+		}
+		if match != "" {
+			log.Debug("Context ", name, " matches ", match)
+			name = match
+		}
+	}
+	if err := checkSwitch(name, create, "--create"); err != nil {
+		return err
+	}
+
+	setDefaultContext(name)
+	return saveConfig()
+}
+
+// checkSwitch fails for a context missing in the config unless create is set with
+// createFlag, typos get suggestions
+func checkSwitch(name string, create bool, createFlag string) error {
+	if hasContext(name) {
+		return nil
+	}
+	if !create {
+		if suggestions := suggestContexts(name); len(suggestions) > 0 {
+			return fmt.Errorf("%w: %q, did you mean %s? Switch anyway with %s", ErrContextMissing, name, strings.Join(suggestions, " or "), createFlag)
+		}
+		return fmt.Errorf("%w: %q, set it up with `clh config set-context %s -e <endpoint>` or switch anyway with %s", ErrContextMissing, name, name, createFlag)
+	}
+	log.Info("Context ", name, " is new, set it up with `clh config`")
+	return nil
+}
+
+// contextNames returns sorted names of all contexts found in the config files
+func contextNames() []string {
+	var names []string
+	for name, value := range viper.AllSettings() {
+		if viper.InConfig(name) && isContext(value) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchContext returns the only context starting with prefix, empty if there is none
+func matchContext(prefix string) (string, error) {
+	var matches []string
+	for _, name := range contextNames() {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%w: %q matches %s", ErrContextAmbiguous, prefix, strings.Join(matches, ", "))
+	}
+}
+
+// suggestContexts returns quoted contexts a typo of name may be meant for, like cobra does for commands
+func suggestContexts(name string) []string {
+	var suggestions []string
+	for _, context := range contextNames() {
+		if editDistance(name, context) <= suggestionsDistance {
+			suggestions = append(suggestions, strconv.Quote(context))
+		}
+	}
+	return suggestions
+}
+
+// editDistance is the Levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func hasContext(name string) bool {
+	return inSlice(name, contextNames())
+}
+
+// isContext reports whether a config value looks like a context section
+func isContext(value interface{}) bool {
+	section, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, key := range contextKeys {
+		if _, ok := section[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecret hides a secret value but still tells whether it is set
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestContextRenameKeepsFileSettingsOnly(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  endpoint: https://dev.example.com/\n  username: bob\n", schemaVersion))
+	e.env = append(e.env, "CLH_DEV_SECRET_KEY=supersecret")
+
+	e.mustRun("-k", "flagsecret", "context", "rename", "dev", "prod")
+
+	config := e.readConfig()
+	for _, unwanted := range []string{"dev:", "secret", "output", "supersecret", "flagsecret"} {
+		if strings.Contains(config, unwanted) {
+			t.Errorf("config holds %q after rename:\n%s", unwanted, config)
+		}
+	}
+	for _, wanted := range []string{"context: prod", "prod:", "endpoint: https://dev.example.com/", "username: bob"} {
+		if !strings.Contains(config, wanted) {
+			t.Errorf("config misses %q after rename:\n%s", wanted, config)
+		}
+	}
+}
+
+func TestContextRenameExisting(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ndev:\n  username: bob\nprod:\n  username: alice\n  token: t\n", schemaVersion))
+
+	if result := e.run("context", "rename", "dev", "prod"); result.code == 0 {
+		t.Fatal("rename over an existing context succeeded without --force")
+	}
+	e.mustRun("context", "rename", "dev", "prod", "--force")
+	config := e.readConfig()
+	if !strings.Contains(config, "username: bob") || strings.Contains(config, "alice") || strings.Contains(config, "token") {
+		t.Errorf("prod not replaced by dev:\n%s", config)
+	}
+}
+
+func TestContextUse(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		arg     string
+		context string
+		code    int
+		message string
+	}{
+		{"exact", "prod", "prod", 0, ""},
+		{"prefix", "stag", "staging", 0, ""},
+		{"ambiguous", "pro", "", ExitConfig, `ambiguous context: "pro" matches prod, production`},
+		{"suggestion", "stagign", "", ExitConfig, `did you mean "staging"?`},
+		{"missing", "qa", "", ExitConfig, "set it up with `clh config set-context qa"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  username: a\nprod:\n  username: b\nproduction:\n  username: c\nstaging:\n  username: d\n", schemaVersion))
+
+			result := e.run("context", "use", tc.arg)
+			if result.code != tc.code {
+				t.Fatalf("exit code %d, expected %d: %s", result.code, tc.code, result.stderr)
+			}
+			if !strings.Contains(result.logs(), tc.message) {
+				t.Errorf("expected %q in %q", tc.message, result.logs())
+			}
+			expected := tc.context
+			if expected == "" {
+				expected = "dev"
+			}
+			if current := e.mustRun("context", "current").stdout; current != expected+"\n" {
+				t.Errorf("current context is %q, expected %q", current, expected)
+			}
+		})
+	}
+}
+
+func TestUnknownCommandSuggestion(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"contxt"}, `did you mean "context"?`},
+		{[]string{"context", "uze"}, `did you mean "use"?`},
+		{[]string{"config", "sett", "x", "y"}, `"set"`},
+		{[]string{"zzzzzz"}, "see `clh --help`"},
+	} {
+		e := newTestEnv(t)
+		result := e.run(tc.args...)
+		if result.code == 0 || !strings.Contains(result.logs(), tc.message) {
+			t.Errorf("clh %s: expected a failure with %q, got %d: %s", strings.Join(tc.args, " "), tc.message, result.code, result.logs())
+		}
+	}
+}
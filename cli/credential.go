@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// contextCredentials returns username and secret key of a context, asking its
+// credential_helper when there is one so the secret is never stored in the config
+func contextCredentials(context string) (string, string, error) {
+	username := viper.GetString(context + ".username")
+	helper := viper.GetString(context + ".credential_helper")
+	// --secret_key given for this run wins over the helper
+	if helper == "" || rootCli.PersistentFlags().Changed("secret_key") || rootCli.PersistentFlags().Changed("secret_key-file") {
+		return username, viper.GetString(context + ".secret_key"), nil
+	}
+
+	helperUsername, secretKey, err := runCredentialHelper(helper, contextEndpoints(context)[0])
+	if err != nil {
+		return "", "", fmt.Errorf("context %q: %v", context, err)
+	}
+	if username == "" {
+		username = helperUsername
+	}
+	return username, secretKey, nil
+}
+
+// runCredentialHelper runs `<helper> get` with the endpoint on stdin like docker and git do.
+// The helper prints either the bare secret key or JSON with secret_key (or Secret) and
+// optionally username (or Username).
+func runCredentialHelper(helper, endpoint string) (string, string, error) {
+	args := strings.Fields(helper)
+	if len(args) == 0 {
+		return "", "", errors.New("credential helper is empty")
+	}
+	cmd := exec.CommandContext(rootCtx, args[0], append(args[1:], "get")...)
+	cmd.Stdin = strings.NewReader(endpoint + "\n")
+	cmd.Stderr = os.Stderr
+	log.Debug("Running credential helper: ", helper)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("credential helper %q failed: %v", helper, err)
+	}
+
+	out = bytes.TrimSpace(out)
+	var creds struct {
+		Username     string `json:"username"`
+		SecretKey    string `json:"secret_key"`
+		DockerUser   string `json:"Username"`
+		DockerSecret string `json:"Secret"`
+	}
+	if bytes.HasPrefix(out, []byte("{")) {
+		if err := json.Unmarshal(out, &creds); err != nil {
+			return "", "", fmt.Errorf("can't decode credential helper output: %v", err)
+		}
+	} else {
+		creds.SecretKey = string(out)
+	}
+
+	username, secretKey := creds.Username, creds.SecretKey
+	if username == "" {
+		username = creds.DockerUser
+	}
+	if secretKey == "" {
+		secretKey = creds.DockerSecret
+	}
+	if secretKey == "" {
+		return "", "", errors.New("credential helper " + helper + " returned no secret key")
+	}
+	return username, secretKey, nil
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeHelper writes a credential helper script printing output, it records its
+// arguments and stdin next to it
+func (e *testEnv) writeHelper(output string) string {
+	e.t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		e.t.Skip("sh not installed, credential helpers not run")
+	}
+	fileName := filepath.Join(e.home, "helper.sh")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %[1]s.args\ncat > %[1]s.stdin\n%s\n", fileName, output)
+	if err := ioutil.WriteFile(fileName, []byte(script), 0700); err != nil {
+		e.t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestCredentialHelper(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		username string
+		output   string
+	}{
+		{"bare key", hubUsername, "echo " + hubSecretKey},
+		{"json", "", fmt.Sprintf(`echo '{"username": "%s", "secret_key": "%s"}'`, hubUsername, hubSecretKey)},
+		{"docker json", "", fmt.Sprintf(`echo '{"Username": "%s", "Secret": "%s"}'`, hubUsername, hubSecretKey)},
+		{"username of config wins", hubUsername, fmt.Sprintf(`echo '{"username": "other", "secret_key": "%s"}'`, hubSecretKey)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newFakeHub(t)
+			e := newTestEnv(t)
+			helper := e.writeHelper(tc.output)
+			e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: local\nlocal:\n  endpoint: %s\n  username: %q\n  credential_helper: %s\n",
+				schemaVersion, hub.endpoint(), tc.username, helper))
+
+			e.mustRun("login")
+			config := e.readConfig()
+			if !strings.Contains(config, "token: "+hubToken) {
+				t.Errorf("token not saved:\n%s", config)
+			}
+			if strings.Contains(config, hubSecretKey) {
+				t.Errorf("secret key of the helper saved:\n%s", config)
+			}
+			args, _ := ioutil.ReadFile(helper + ".args")
+			stdin, _ := ioutil.ReadFile(helper + ".stdin")
+			if string(args) != "get\n" || string(stdin) != hub.endpoint()+"\n" {
+				t.Errorf("helper run with %q and stdin %q", args, stdin)
+			}
+		})
+	}
+}
+
+func TestCredentialHelperFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		output  string
+		message string
+	}{
+		{"exit code", "exit 3", "failed"},
+		{"no secret", "echo '{}'", "returned no secret key"},
+		{"broken json", "echo '{broken'", "can't decode credential helper output"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newFakeHub(t)
+			e := newTestEnv(t)
+			helper := e.writeHelper(tc.output)
+			e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: local\nlocal:\n  endpoint: %s\n  username: %s\n  credential_helper: %s\n",
+				schemaVersion, hub.endpoint(), hubUsername, helper))
+
+			result := e.run("login")
+			if result.code == 0 || !strings.Contains(result.logs(), tc.message) {
+				t.Errorf("exit code %d, expected %q: %s", result.code, tc.message, result.stderr)
+			}
+			if got := hub.received(); len(got) != 0 {
+				t.Errorf("hub called after helper failure: %v", got)
+			}
+		})
+	}
+}
+
+// --secret_key given for a run is used instead of asking the helper
+func TestCredentialHelperOverridden(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	helper := e.writeHelper("exit 1")
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: local\nlocal:\n  endpoint: %s\n  username: %s\n  credential_helper: %s\n",
+		schemaVersion, hub.endpoint(), hubUsername, helper))
+
+	e.mustRun("login", "--secret_key", hubSecretKey)
+	if _, err := ioutil.ReadFile(helper + ".args"); err == nil {
+		t.Error("helper run despite --secret_key")
+	}
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+// Deployments are asynchronous, the Hub answers with an operation polled under operationsPath
+const (
+	deploymentsPath = "v1/deployments"
+	operationsPath  = "v1/operations/"
+)
+
+var deployCli = &cobra.Command{
+	Use:               "deploy <application>",
+	Short:             "Deploy an application",
+	Long:              "Start a deployment of an application on the Hub of the current context, --wait waits for it to finish",
+	Args:              cobra.ExactArgs(1),
+	PersistentPreRunE: requireCredentials,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := contextClient()
+		if err != nil {
+			return err
+		}
+		version, _ := cmd.Flags().GetString("version")
+		request := struct {
+			Application string `json:"application"`
+			Version     string `json:"version,omitempty"`
+		}{args[0], version}
+
+		op := &operation{}
+		if err := c.Do("POST", deploymentsPath, request, op); err != nil {
+			return err
+		}
+		if op.ID == "" {
+			return errors.New("deployment started but no operation received")
+		}
+		return renderOperation(cmd, c, op, operationsPath+op.ID)
+	},
+}
+
+func init() {
+	// Deploy
+
+	deployCli.Flags().StringP("version", "", "", "Version to deploy, the latest one unless set")
+	addWaitFlag(deployCli)
+	rootCli.AddCommand(deployCli)
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// deployHub starts a deployment of op-1 as running, polls of it answer with statuses in
+// turn, the last one for good
+func deployHub(t *testing.T, statuses ...string) *fakeHub {
+	t.Helper()
+	hub := newFakeHub(t)
+	hub.handle("POST /v1/deployments", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Application string `json:"application"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Application != "shop" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "no application " + request.Application})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, operation{ID: "op-1", Status: "running"})
+	})
+	hub.handle("GET /v1/operations/op-1", func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		writeJSON(w, http.StatusOK, operation{ID: "op-1", Status: status})
+	})
+	return hub
+}
+
+func TestDeploy(t *testing.T) {
+	hub := deployHub(t, "succeeded")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("deploy", "shop", "-o", "json")
+	var op operation
+	if err := json.Unmarshal([]byte(result.stdout), &op); err != nil {
+		t.Fatalf("can't decode %q: %v", result.stdout, err)
+	}
+	if op.ID != "op-1" || op.Status != "running" {
+		t.Errorf("unexpected operation: %+v", op)
+	}
+	if got := hub.received(); len(got) != 1 {
+		t.Errorf("operation polled without --wait: %v", got)
+	}
+}
+
+func TestDeployWait(t *testing.T) {
+	t.Parallel()
+	hub := deployHub(t, "succeeded")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("deploy", "shop", "--wait", "-o", "json")
+	if !strings.Contains(result.stdout, `"status": "succeeded"`) {
+		t.Errorf("final status not printed: %q", result.stdout)
+	}
+	if !strings.Contains(result.stderr, "Operation op-1 succeeded") {
+		t.Errorf("end of the operation not logged: %q", result.stderr)
+	}
+	if got := hub.received(); len(got) != 2 || got[1] != "GET /v1/operations/op-1" {
+		t.Errorf("unexpected requests: %v", got)
+	}
+}
+
+func TestDeployWaitFailed(t *testing.T) {
+	t.Parallel()
+	hub := deployHub(t, "failed")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.run("deploy", "shop", "--wait")
+	if result.code != ExitError || !strings.Contains(result.logs(), "operation op-1 failed") {
+		t.Errorf("exit code %d, expected %d for a failed operation: %s", result.code, ExitError, result.stderr)
+	}
+	if !strings.Contains(result.stdout, "failed") {
+		t.Errorf("failed operation not printed: %q", result.stdout)
+	}
+}
+
+func TestDeployWaitTimeout(t *testing.T) {
+	t.Parallel()
+	hub := deployHub(t, "running")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.run("deploy", "shop", "--wait", "--timeout", "1s")
+	if result.code == 0 || !strings.Contains(result.logs(), "operation op-1 is still running after 1s") {
+		t.Errorf("expected a timeout, got %d: %s", result.code, result.stderr)
+	}
+}
+
+func TestDeployUnknownApplication(t *testing.T) {
+	hub := deployHub(t, "succeeded")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	if result := e.run("deploy", "nope", "--wait"); result.code != ExitAPI {
+		t.Errorf("exit code %d, expected %d: %s", result.code, ExitAPI, result.stderr)
+	}
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Unchanged lines shown around each change
+const diffContext = 3
+
+var configDiffCli = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what env, flags and defaults change",
+	Long:  "Print a unified diff of the config files merged as is against the effective config after env, flags and defaults",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := fileConfig.AllSettings()
+		effective := viper.AllSettings()
+		// Path of the config itself is no setting
+		delete(files, "config")
+		delete(effective, "config")
+		if showSecrets, _ := cmd.Flags().GetBool("show-secrets"); !showSecrets {
+			maskSettings(files)
+			maskSettings(effective)
+		}
+
+		from, err := yaml.Marshal(files)
+		if err != nil {
+			return fmt.Errorf("can't render config: %v", err)
+		}
+		to, err := yaml.Marshal(effective)
+		if err != nil {
+			return fmt.Errorf("can't render config: %v", err)
+		}
+		fmt.Fprint(resultWriter(), unifiedDiff(diffLines(string(from)), diffLines(string(to)), "config files", "effective"))
+		return nil
+	},
+}
+
+func init() {
+	// Config Diff
+
+	configDiffCli.Flags().BoolP("show-secrets", "", false, "Print secret values as is")
+	configCli.AddCommand(configDiffCli)
+}
+
+// diffOp is a line kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines splits yaml into lines, an empty map has none
+func diffLines(text string) []string {
+	if text == "" || text == "{}\n" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff formats the changes from a to b like `diff -u`, empty if they are equal
+func unifiedDiff(a, b []string, fromName, toName string) string {
+	ops := diffOps(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and the end of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last, kept := first, 0
+		for i := first; i < len(ops) && kept <= 2*diffContext; i++ {
+			if ops[i].kind == ' ' {
+				kept++
+			} else {
+				last, kept = i, 0
+			}
+		}
+
+		from, to := first-diffContext, last+diffContext+1
+		if from < start {
+			from = start
+		}
+		if to > len(ops) {
+			to = len(ops)
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		// Line numbers of the hunk in a and b, counted from the ops before it
+		aLine, bLine, aCount, bCount := 1, 1, 0, 0
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, op := range ops[from:to] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line + "\n")
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// diffOps returns the edit script from a to b through their longest common subsequence
+func diffOps(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		// Removed lines go first, like diff does
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// hunkRange formats start,count of a hunk header, an empty range starts one line earlier
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
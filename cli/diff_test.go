@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConfigDiff(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  username: bob\n  secret_key: s3cret\n", schemaVersion))
+	e.env = append(e.env, "CLH_DEV_USERNAME=fromenv")
+
+	diff := e.mustRun("config", "diff", "--proxy", "http://proxy.example:3128", "-k", "other").stdout
+	for _, wanted := range []string{"--- config files\n+++ effective\n", "\n-  username: bob\n", "\n+  username: fromenv\n",
+		"\n+proxy: http://proxy.example:3128\n", "\n+  endpoint: " + defaultEndpoint + "\n", "\n   secret_key: '********'\n"} {
+		if !strings.Contains(diff, wanted) {
+			t.Errorf("diff misses %q:\n%s", wanted, diff)
+		}
+	}
+	if strings.Contains(diff, "s3cret") || strings.Contains(diff, "other") {
+		t.Errorf("secrets in diff:\n%s", diff)
+	}
+
+	diff = e.mustRun("config", "diff", "-k", "other", "--show-secrets").stdout
+	if !strings.Contains(diff, "\n-  secret_key: s3cret\n") || !strings.Contains(diff, "\n+  secret_key: other\n") {
+		t.Errorf("secrets hidden despite --show-secrets:\n%s", diff)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"changed", "a\nb\nc\n", "a\nx\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"added to empty", "{}\n", "a\n", "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n"},
+		{"hunks apart", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := unifiedDiff(diffLines(tc.a), diffLines(tc.b), "a", "b"); got != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, got)
+			}
+		})
+	}
+}
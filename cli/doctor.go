@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configDoctorCli = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the config and the current context",
+	Long:  "Check the config file, the current context and its credentials, --ping also checks the Hub is reachable",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report := doctorReport{}
+		fileName := viper.GetString("config")
+		context := viper.GetString("context")
+
+		var err error
+		// Piped configs exist as long as they could be read
+		if fileName != stdinConfigName {
+			_, err = os.Stat(fileName)
+		}
+		report.add("config file exists", err, "create one with `clh config init`")
+		if err == nil {
+			_, err = readConfigFile(fileName)
+			report.add("config file is valid", err, "fix the syntax of "+fileName)
+		}
+
+		err = nil
+		for _, endpoint := range contextEndpoints(context) {
+			if endpoint == "" {
+				err = fmt.Errorf("context %q has no endpoint", context)
+			} else {
+				err = validateEndpoint(endpoint)
+			}
+			if err != nil {
+				break
+			}
+		}
+		report.add("context "+context+" has a valid endpoint", err, "use `clh config -e <endpoint>`")
+
+		// Its errors already tell what to do
+		report.add("credentials are set", requireCredentials(cmd, args), "")
+
+		err = nil
+		if mode, exposed := secretsExposed(fileName); exposed {
+			err = fmt.Errorf("config holds secrets and has mode %04o", mode)
+		}
+		report.add("config permissions are safe", err, "run `chmod 600 "+fileName+"`")
+
+		if ping, _ := cmd.Flags().GetBool("ping"); ping {
+			report.add("hub is reachable", pingHub(), "check the endpoint, --proxy and network")
+		}
+
+		if err := render(report); err != nil {
+			return err
+		}
+		if failed := report.failed(); failed > 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(report.Checks))
+		}
+		return nil
+	},
+}
+
+// pingHub checks the health endpoint of the current context answers
+func pingHub() error {
+	c, err := contextClient()
+	if err != nil {
+		return err
+	}
+	resp, _, err := c.send(http.MethodGet, healthPath, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hub is not healthy: %s", resp.Status)
+	}
+	return nil
+}
+
+type doctorCheck struct {
+	Check  string `json:"check" yaml:"check"`
+	OK     bool   `json:"ok" yaml:"ok"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+	Remedy string `json:"remedy,omitempty" yaml:"remedy,omitempty"`
+}
+
+type doctorReport struct {
+	Checks []doctorCheck `json:"checks" yaml:"checks"`
+}
+
+// add records a check, passed when err is nil
+func (r *doctorReport) add(check string, err error, remedy string) {
+	if err == nil {
+		r.Checks = append(r.Checks, doctorCheck{Check: check, OK: true})
+		return
+	}
+	r.Checks = append(r.Checks, doctorCheck{Check: check, Error: err.Error(), Remedy: remedy})
+}
+
+func (r doctorReport) failed() int {
+	failed := 0
+	for _, check := range r.Checks {
+		if !check.OK {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (r doctorReport) rows() [][]string {
+	var rows [][]string
+	for _, check := range r.Checks {
+		if check.OK {
+			rows = append(rows, []string{"[ok]", check.Check})
+		} else {
+			msg := check.Check + ": " + check.Error
+			if check.Remedy != "" {
+				msg += ", " + check.Remedy
+			}
+			rows = append(rows, []string{"[fail]", msg})
+		}
+	}
+	return rows
+}
+
+func init() {
+	// Config Doctor
+
+	configDoctorCli.Flags().BoolP("ping", "", false, "Also check the Hub is reachable")
+	configCli.AddCommand(configDoctorCli)
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+)
+
+// Exit codes of clh, scripts may branch on the class of a failure
+const (
+	ExitOK          = 0
+	ExitError       = 1
+	ExitConfig      = 2
+	ExitAuth        = 3
+	ExitNetwork     = 4
+	ExitAPI         = 5
+	ExitInterrupted = 130
+)
+
+// Config failures, match them with errors.Is
+var (
+	ErrConfigNotFound   = errors.New("config not found")
+	ErrConfigWrite      = errors.New("can't save config")
+	ErrContextMissing   = errors.New("context doesn't exist")
+	ErrContextAmbiguous = errors.New("ambiguous context")
+	ErrInvalidEndpoint  = errors.New("invalid endpoint")
+	ErrInvalidKey       = errors.New("unknown key")
+	ErrConfigInvalid    = errors.New("invalid config")
+)
+
+// ErrUnauthenticated is returned when credentials are missing or no longer accepted
+var ErrUnauthenticated = errors.New("not authenticated")
+
+// ErrInterrupted is returned when Ctrl-C or SIGTERM cancels a running command
+var ErrInterrupted = errors.New("interrupted")
+
+var errNoConfig = fmt.Errorf("%w: config files are disabled with --no-config", ErrConfigWrite)
+
+var errNoConfigFile = fmt.Errorf("%w: no home directory, use --config", ErrConfigWrite)
+
+var errReadOnly = fmt.Errorf("%w: config is read-only, drop --read-only or CLH_READ_ONLY to change it", ErrConfigWrite)
+
+var errConfigStdin = fmt.Errorf("%w: config was read from stdin, use --save-to <file>", ErrConfigWrite)
+
+// ExitCode maps an error returned by Execute to the exit code of its class,
+// a plugin failing with an exit code passes it on as is
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	if errors.Is(err, ErrInterrupted) {
+		return ExitInterrupted
+	}
+	for _, configErr := range []error{ErrConfigNotFound, ErrConfigWrite, ErrContextMissing, ErrContextAmbiguous, ErrInvalidEndpoint, ErrInvalidKey, ErrConfigInvalid} {
+		if errors.Is(err, configErr) {
+			return ExitConfig
+		}
+	}
+	if errors.Is(err, ErrUnauthenticated) {
+		return ExitAuth
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+			return ExitAuth
+		}
+		return ExitAPI
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return ExitNetwork
+	}
+	return ExitError
+}
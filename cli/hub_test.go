@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Credentials accepted by a fake hub
+const (
+	hubUsername  = "tester"
+	hubSecretKey = "s3cret"
+	hubToken     = "tok-123"
+)
+
+// fakeHub is a CloudletHub API served by httptest, it records the requests it gets.
+// Routes are "<method> /<path>", handle replaces or adds one.
+type fakeHub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string]http.HandlerFunc
+	requests []string
+}
+
+// newFakeHub starts a hub answering health checks and logins with the hub credentials
+func newFakeHub(t *testing.T) *fakeHub {
+	t.Helper()
+	return startFakeHub(t, nil)
+}
+
+// newTLSHub starts a fake hub serving HTTPS with cert
+func newTLSHub(t *testing.T, cert tls.Certificate) *fakeHub {
+	t.Helper()
+	return startFakeHub(t, &cert)
+}
+
+func startFakeHub(t *testing.T, cert *tls.Certificate) *fakeHub {
+	t.Helper()
+	h := &fakeHub{routes: map[string]http.HandlerFunc{}}
+	h.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	h.handle("POST /v1/login", func(w http.ResponseWriter, r *http.Request) {
+		var credentials struct {
+			Username  string `json:"username"`
+			SecretKey string `json:"secret_key"`
+		}
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials.Username != hubUsername || credentials.SecretKey != hubSecretKey {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "bad credentials"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"token": hubToken})
+	})
+	h.Server = httptest.NewUnstartedServer(http.HandlerFunc(h.serve))
+	if cert != nil {
+		h.TLS = &tls.Config{Certificates: []tls.Certificate{*cert}}
+		h.StartTLS()
+	} else {
+		h.Start()
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *fakeHub) handle(route string, handler http.HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.routes[route] = handler
+}
+
+func (h *fakeHub) serve(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + r.URL.Path
+	h.mu.Lock()
+	h.requests = append(h.requests, route)
+	handler, ok := h.routes[route]
+	h.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "no route " + route})
+		return
+	}
+	handler(w, r)
+}
+
+// received returns the routes requested so far
+func (h *fakeHub) received() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.requests...)
+}
+
+// endpoint is the URL to configure for a context
+func (h *fakeHub) endpoint() string {
+	return h.URL + "/"
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// useHub configures context name as the current one, talking to endpoints
+// in order with the hub credentials
+func (e *testEnv) useHub(name string, endpoints ...string) {
+	e.t.Helper()
+	var list []string
+	for _, endpoint := range endpoints {
+		list = append(list, fmt.Sprintf("%q", endpoint))
+	}
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: %s\n%s:\n  endpoints: [%s]\n  username: %s\n  secret_key: %s\n",
+		schemaVersion, name, name, strings.Join(list, ", "), hubUsername, hubSecretKey))
+}
+
+// closedEndpoint returns an endpoint nothing listens on
+func closedEndpoint(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL + "/"
+	server.Close()
+	return endpoint
+}
+
+func TestLogin(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("login")
+	if !strings.Contains(result.stdout, "Logged in as "+hubUsername) {
+		t.Errorf("unexpected output: %q", result.stdout)
+	}
+	if config := e.readConfig(); !strings.Contains(config, "token: "+hubToken) {
+		t.Errorf("token not saved:\n%s", config)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	config := e.readConfig()
+	result := e.run("login", "--secret_key", "wrong")
+	if result.code != ExitAuth {
+		t.Errorf("exit code %d, expected %d: %s", result.code, ExitAuth, result.stderr)
+	}
+	if e.readConfig() != config {
+		t.Errorf("config changed by a failed login:\n%s", e.readConfig())
+	}
+}
+
+// Nothing is asked from the hub for a token which can't be saved
+func TestLoginReadOnly(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+	config := e.readConfig()
+
+	if result := e.run("login", "--read-only"); result.code != ExitConfig {
+		t.Errorf("exit code %d, expected %d: %s", result.code, ExitConfig, result.stderr)
+	}
+	if got := hub.received(); len(got) != 0 {
+		t.Errorf("hub asked for a token which can't be saved: %v", got)
+	}
+	if e.readConfig() != config {
+		t.Errorf("config changed with --read-only:\n%s", e.readConfig())
+	}
+}
+
+func TestPing(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("ping", "-o", "json")
+	var info pingInfo
+	if err := json.Unmarshal([]byte(result.stdout), &info); err != nil {
+		t.Fatalf("can't decode %q: %v", result.stdout, err)
+	}
+	if !info.Healthy || info.URL != hub.endpoint()+healthPath {
+		t.Errorf("unexpected ping result: %+v", info)
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	e := newTestEnv(t)
+	e.useHub("local", closedEndpoint(t))
+
+	if result := e.run("ping"); result.code != ExitNetwork {
+		t.Errorf("exit code %d, expected %d: %s", result.code, ExitNetwork, result.stderr)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", closedEndpoint(t), hub.endpoint())
+
+	result := e.mustRun("ping", "-o", "json")
+	if !strings.Contains(result.stdout, hub.endpoint()+healthPath) {
+		t.Errorf("ping didn't fail over to %s: %q", hub.endpoint(), result.stdout)
+	}
+	if !strings.Contains(result.stderr, "failing over to "+hub.endpoint()) {
+		t.Errorf("failover not logged: %q", result.stderr)
+	}
+
+	e.mustRun("login")
+	if got := hub.received(); len(got) != 2 || got[1] != "POST /v1/login" {
+		t.Errorf("unexpected requests: %v", got)
+	}
+}
+
+// HTTP errors are answers of the hub, the next endpoint must not get the request
+func TestNoFailoverOnHTTPError(t *testing.T) {
+	broken := newFakeHub(t)
+	broken.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "down"})
+	})
+	hub := newFakeHub(t)
+	e := newTestEnv(t)
+	e.useHub("local", broken.endpoint(), hub.endpoint())
+
+	e.run("ping")
+	if got := hub.received(); len(got) != 0 {
+		t.Errorf("second endpoint got requests: %v", got)
+	}
+}
+
+// Health checks are unauthenticated, neither credentials nor the token may leak to them
+func TestPingSendsNoCredentials(t *testing.T) {
+	hub := newFakeHub(t)
+	hub.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "credentials sent: " + auth})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	e := newTestEnv(t)
+	helper := filepath.Join(e.home, "helper-ran")
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: local\nlocal:\n  endpoint: %s\n  username: %s\n  token: %s\n  credential_helper: touch %s\n",
+		schemaVersion, hub.endpoint(), hubUsername, hubToken, helper))
+
+	if result := e.run("ping"); result.code != 0 {
+		t.Errorf("ping failed: %s%s", result.stdout, result.stderr)
+	}
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: local\nlocal:\n  endpoint: %s\n  username: %s\n  credential_helper: touch %s\n",
+		schemaVersion, hub.endpoint(), hubUsername, helper))
+	if result := e.run("ping", "-k", hubSecretKey); result.code != 0 {
+		t.Errorf("ping failed: %s%s", result.stdout, result.stderr)
+	}
+	if _, err := os.Stat(helper); !os.IsNotExist(err) {
+		t.Error("credential helper run for ping")
+	}
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config is read while the package is initialized, so every clh run of a test
+// is a process of its own: the test binary started again as clh
+const testMainEnv = "CLH_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) == "1" {
+		log.SetOutput(os.Stderr)
+		err := Execute()
+		if err != nil {
+			log.Error(err)
+		}
+		log.Exit(ExitCode(err))
+	}
+	os.Exit(m.Run())
+}
+
+// testEnv is a throwaway home to run clh in, no config of the user is read
+type testEnv struct {
+	t    *testing.T
+	home string
+	// Passed to every run on top of a clean environment
+	env []string
+	// Piped to every run, e.g. for --config -
+	stdin string
+}
+
+// runResult is what a clh run printed and its exit code
+type runResult struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	return &testEnv{t: t, home: t.TempDir()}
+}
+
+// run runs clh with args in the home of e
+func (e *testEnv) run(args ...string) runResult {
+	e.t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = e.home
+	cmd.Env = append(cleanEnv(), "HOME="+e.home, testMainEnv+"=1")
+	cmd.Env = append(cmd.Env, e.env...)
+	cmd.Stdin = strings.NewReader(e.stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	result := runResult{stdout: stdout.String(), stderr: stderr.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.code = exitErr.ExitCode()
+	} else if err != nil {
+		e.t.Fatalf("can't run clh %s: %v", strings.Join(args, " "), err)
+	}
+	return result
+}
+
+// logs returns stderr with quotes of logrus text logs unescaped, for matching messages
+func (r runResult) logs() string {
+	return strings.Replace(r.stderr, `\"`, `"`, -1)
+}
+
+// mustRun runs clh and fails the test unless it succeeds
+func (e *testEnv) mustRun(args ...string) runResult {
+	e.t.Helper()
+	result := e.run(args...)
+	if result.code != 0 {
+		e.t.Fatalf("clh %s exited with %d: %s", strings.Join(args, " "), result.code, result.stderr)
+	}
+	return result
+}
+
+// configFile is the config saved when none exists yet
+func (e *testEnv) configFile() string {
+	return filepath.Join(e.home, ".clh", "config.yaml")
+}
+
+func (e *testEnv) writeConfig(content string) {
+	e.t.Helper()
+	if err := os.MkdirAll(filepath.Dir(e.configFile()), 0700); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(e.configFile(), []byte(content), 0600); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *testEnv) readConfig() string {
+	e.t.Helper()
+	data, err := ioutil.ReadFile(e.configFile())
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return string(data)
+}
+
+// cleanEnv drops settings of the user running the tests from the environment
+func cleanEnv() []string {
+	var env []string
+	for _, v := range os.Environ() {
+		name := strings.SplitN(v, "=", 2)[0]
+		if strings.HasPrefix(name, "CLH_") || name == "HOME" || name == "XDG_CONFIG_HOME" || name == "NO_COLOR" {
+			continue
+		}
+		env = append(env, v)
+	}
+	return env
+}
@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Config schema written by this build, files without schema_version are version 0
+const schemaVersion = 1
+
+// migrations[i] upgrades settings of version i to i+1
+var migrations = []func(settings map[string]interface{}) []configChange{
+	dropBakedSettings,
+}
+
+var configMigrateCli = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the config file to the current schema",
+	Long:  "Rewrite the config file in the current schema, the original is kept as <config>.bak",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileName := viper.GetString("config")
+		settings, err := readConfigFile(fileName)
+		if err != nil {
+			return err
+		}
+		if len(settings) == 0 {
+			return fmt.Errorf("%w: %s", ErrConfigNotFound, fileName)
+		}
+
+		version := schemaVersionOf(settings)
+		if version < 0 {
+			return fmt.Errorf("%w: %s has schema version %d, it can't be negative", ErrConfigInvalid, fileName, version)
+		}
+		if version > schemaVersion {
+			return fmt.Errorf("%w: %s has schema version %d, this clh only knows up to %d", ErrConfigInvalid, fileName, version, schemaVersion)
+		}
+		if version == schemaVersion {
+			printResult("Config " + fileName + " is up to date")
+			return nil
+		}
+
+		for _, migrate := range migrations[version:] {
+			changes := migrate(settings)
+			applyChanges(settings, changes)
+			configChanges = append(configChanges, changes...)
+		}
+		setConfig("schema_version", schemaVersion)
+
+		if dryRun, _ := rootCli.PersistentFlags().GetBool("dry-run"); dryRun {
+			return saveConfig()
+		}
+		// saveConfig keeps the original as a backup
+		if err := saveConfig(); err != nil {
+			return err
+		}
+		fmt.Fprintf(resultWriter(), "Config %s migrated from schema version %d to %d\n", fileName, version, schemaVersion)
+		return nil
+	},
+}
+
+var configRenameKeyCli = &cobra.Command{
+	Use:   "rename-key <old> <new>",
+	Short: "Move a value to another key",
+	Long: `Move a value such as prod.user to prod.username keeping its type, e.g. after a key was renamed.
+Keys without a context, e.g. user username, are renamed in every context. Set new keys require --force`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldKey, newKey := strings.ToLower(args[0]), strings.ToLower(args[1])
+		if strings.Contains(oldKey, ".") != strings.Contains(newKey, ".") {
+			return fmt.Errorf("can't rename %q to %q, use two <context>.<key> or two context keys", oldKey, newKey)
+		}
+		if oldKey == newKey {
+			return nil
+		}
+
+		fileName := viper.GetString("config")
+		settings, err := readConfigFile(fileName)
+		if err != nil {
+			return err
+		}
+
+		var changes []configChange
+		if strings.Contains(newKey, ".") {
+			if err := validateKey(newKey); err != nil {
+				return err
+			}
+			changes = renameKey(settings, oldKey, newKey)
+		} else {
+			if !inSlice(newKey, contextKeys) {
+				return fmt.Errorf("%w %q, known context keys are: %s", ErrInvalidKey, newKey, strings.Join(contextKeys, ", "))
+			}
+			changes = renameContextKey(settings, oldKey, newKey)
+		}
+		if len(changes) == 0 {
+			return fmt.Errorf("key %q is not set in %s", oldKey, fileName)
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		for _, change := range changes {
+			if _, ok := getKey(settings, strings.Split(change.key, ".")); ok && !change.unset && !force {
+				return fmt.Errorf("key %q is already set, use --force to overwrite it", change.key)
+			}
+		}
+		configChanges = append(configChanges, changes...)
+		return saveConfig()
+	},
+}
+
+// schemaVersionOf reads schema_version, numbers decode differently in yaml, json and toml
+func schemaVersionOf(settings map[string]interface{}) int {
+	switch version := settings["schema_version"].(type) {
+	case int:
+		return version
+	case int64:
+		return int(version)
+	case float64:
+		return int(version)
+	default:
+		return 0
+	}
+}
+
+// dropBakedSettings removes what version 0 saved without being asked to:
+// the path of the config itself and empty values of unset flags
+func dropBakedSettings(settings map[string]interface{}) []configChange {
+	var changes []configChange
+	if _, ok := settings["config"]; ok {
+		changes = append(changes, configChange{key: "config", unset: true})
+	}
+	for _, key := range sortedKeys(settings) {
+		switch value := settings[key].(type) {
+		case string:
+			if value == "" {
+				changes = append(changes, configChange{key: key, unset: true})
+			}
+		case map[string]interface{}:
+			for _, sub := range sortedKeys(value) {
+				if value[sub] == "" {
+					changes = append(changes, configChange{key: key + "." + sub, unset: true})
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// renameKey moves a value to another key as is, keeping its type, for migrations
+// of renamed keys and `clh config rename-key`. Nothing happens if it is not set.
+func renameKey(settings map[string]interface{}, oldKey, newKey string) []configChange {
+	value, ok := getKey(settings, strings.Split(oldKey, "."))
+	if !ok {
+		return nil
+	}
+	return []configChange{
+		{key: newKey, value: value},
+		{key: oldKey, unset: true},
+	}
+}
+
+// renameContextKey renames a key in every context having it
+func renameContextKey(settings map[string]interface{}, oldKey, newKey string) []configChange {
+	var changes []configChange
+	for _, name := range sortedKeys(settings) {
+		if _, ok := settings[name].(map[string]interface{}); ok && !inSlice(name, globalKeys) {
+			changes = append(changes, renameKey(settings, name+"."+oldKey, name+"."+newKey)...)
+		}
+	}
+	return changes
+}
+
+func sortedKeys(settings map[string]interface{}) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	// Config Migrate
+
+	configCli.AddCommand(configMigrateCli)
+
+	// Config Rename Key
+
+	configRenameKeyCli.Flags().BoolP("force", "f", false, "Overwrite the new key if it is set")
+	configCli.AddCommand(configRenameKeyCli)
+}
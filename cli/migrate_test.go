@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMigrateInvalidSchemaVersion(t *testing.T) {
+	for _, version := range []int{-1, schemaVersion + 1} {
+		e := newTestEnv(t)
+		config := fmt.Sprintf("schema_version: %d\ndefault:\n  username: bob\n", version)
+		e.writeConfig(config)
+
+		result := e.run("config", "migrate")
+		if result.code != ExitConfig || strings.Contains(result.stderr, "internal error") {
+			t.Errorf("schema version %d: exit code %d, expected %d: %s", version, result.code, ExitConfig, result.stderr)
+		}
+		if e.readConfig() != config {
+			t.Errorf("schema version %d: config changed:\n%s", version, e.readConfig())
+		}
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig("default:\n  username: bob\n")
+
+	e.mustRun("config", "migrate")
+	migrated := e.readConfig()
+	if !strings.Contains(migrated, fmt.Sprintf("schema_version: %d", schemaVersion)) {
+		t.Errorf("schema version not bumped:\n%s", migrated)
+	}
+	if result := e.mustRun("config", "migrate"); !strings.Contains(result.stdout, "is up to date") {
+		t.Errorf("second migration did something: %q", result.stdout)
+	}
+	if e.readConfig() != migrated {
+		t.Errorf("second migration changed the config:\n%s", e.readConfig())
+	}
+}
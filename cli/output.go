@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Output formats supported by --output
+var outputFormats = []string{"table", "json", "yaml"}
+
+// tabular results know how to lay themselves out as table rows
+type tabular interface {
+	rows() [][]string
+}
+
+// Results kept for --output-file, written by writeResults once the command is over
+var results bytes.Buffer
+
+// render prints a command result in the format chosen by --output,
+// --format-template wins over it. --output-file gets the result instead of stdout, logs never.
+func render(result interface{}) error {
+	// Nothing of a result failing to render is printed
+	var out bytes.Buffer
+	if err := renderTo(&out, result); err != nil {
+		return err
+	}
+	_, err := resultWriter().Write(out.Bytes())
+	return err
+}
+
+// resultWriter is where commands print their results, stdout unless --output-file is given
+func resultWriter() io.Writer {
+	if fileName, _ := rootCli.PersistentFlags().GetString("output-file"); fileName != "" {
+		return &results
+	}
+	return os.Stdout
+}
+
+// printResult prints a line of a result like fmt.Println
+func printResult(a ...interface{}) {
+	fmt.Fprintln(resultWriter(), a...)
+}
+
+// writeResults saves what commands printed to --output-file, a command printing
+// nothing leaves the file alone
+func writeResults() error {
+	fileName, _ := rootCli.PersistentFlags().GetString("output-file")
+	if fileName == "" || results.Len() == 0 {
+		return nil
+	}
+	if err := ioutil.WriteFile(fileName, results.Bytes(), 0644); err != nil {
+		return fmt.Errorf("can't write output: %v", err)
+	}
+	return nil
+}
+
+func renderTo(out io.Writer, result interface{}) error {
+	if text, _ := rootCli.PersistentFlags().GetString("format-template"); text != "" {
+		return renderTemplate(out, text, result)
+	}
+
+	switch format := outputFormat(); format {
+	case "table":
+		t, ok := result.(tabular)
+		if !ok {
+			return fmt.Errorf("%T can't be rendered as a table", result)
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, row := range t.rows() {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	case "json":
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("can't render json: %v", err)
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("can't render yaml: %v", err)
+		}
+		_, err = out.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown output format %q, use one of: %s", format, strings.Join(outputFormats, ", "))
+	}
+}
+
+// outputFormat returns the --output of the current context
+func outputFormat() string {
+	return viper.GetString(viper.GetString("context") + ".output")
+}
+
+// renderTemplate prints a result through a Go template, e.g. '{{.Version}}'
+func renderTemplate(w io.Writer, text string, result interface{}) error {
+	tmpl, err := template.New("format-template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid --format-template: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, result); err != nil {
+		return fmt.Errorf("can't render --format-template: %v", err)
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteString("\n")
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFile(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"context", "list"}, "  dev\n* prod\n"},
+		{[]string{"context", "current"}, "prod\n"},
+		{[]string{"config", "get", "dev.username"}, "alice\n"},
+		{[]string{"context", "show", "-o", "json", "--format-template", "{{.Username}}"}, "bob\n"},
+	} {
+		e := newTestEnv(t)
+		e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: prod\ndev:\n  username: alice\nprod:\n  username: bob\n", schemaVersion))
+		output := filepath.Join(e.home, "out.txt")
+
+		result := e.mustRun(append(tc.args, "--output-file", output)...)
+		if result.stdout != "" {
+			t.Errorf("clh %v printed to stdout with --output-file: %q", tc.args, result.stdout)
+		}
+		data, err := ioutil.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.expected {
+			t.Errorf("clh %v wrote %q, expected %q", tc.args, data, tc.expected)
+		}
+	}
+}
+
+func TestOutputFileUntouchedOnError(t *testing.T) {
+	e := newTestEnv(t)
+	output := filepath.Join(e.home, "out.txt")
+	if err := ioutil.WriteFile(output, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := e.run("config", "get", "nothing.here", "--output-file", output); result.code == 0 {
+		t.Fatal("config get of a missing key succeeded")
+	}
+	if data, _ := ioutil.ReadFile(output); string(data) != "previous\n" {
+		t.Errorf("output file changed by a failed command: %q", data)
+	}
+	if result := e.run("version", "--short", "--output-file", filepath.Join(e.home, "missing", "out.txt")); result.code == 0 {
+		t.Error("unwritable output file not reported")
+	}
+	if _, err := os.Stat(filepath.Join(e.home, "missing")); !os.IsNotExist(err) {
+		t.Error("directory of the output file created")
+	}
+}
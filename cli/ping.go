@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Health check path, answered without authentication
+const healthPath = "health"
+
+var pingCli = &cobra.Command{
+	Use:               "ping",
+	Short:             "Check the Hub is reachable",
+	Long:              "Query the health endpoint of the current context, without credentials, and report status and latency",
+	PersistentPreRunE: requireContext,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Health checks are unauthenticated, no credentials are sent nor a helper run for them
+		c, err := newAPIClient(contextEndpoints(viper.GetString("context")), "", "")
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		resp, _, err := c.send(http.MethodGet, healthPath, nil)
+		if err != nil {
+			return fmt.Errorf("can't reach %s: %w", c.baseURL, err)
+		}
+
+		info := pingInfo{
+			// Endpoint which answered, after a failover
+			URL:     c.url(healthPath),
+			Status:  resp.Status,
+			Latency: time.Since(start).Round(time.Millisecond).String(),
+			Healthy: resp.StatusCode == http.StatusOK,
+		}
+		if err := render(info); err != nil {
+			return err
+		}
+		if !info.Healthy {
+			return fmt.Errorf("hub is not healthy: %s", info.Status)
+		}
+		return nil
+	},
+}
+
+type pingInfo struct {
+	URL     string `json:"url" yaml:"url"`
+	Status  string `json:"status" yaml:"status"`
+	Latency string `json:"latency" yaml:"latency"`
+	Healthy bool   `json:"healthy" yaml:"healthy"`
+}
+
+func (p pingInfo) rows() [][]string {
+	return [][]string{
+		{"url:", p.URL},
+		{"status:", p.Status},
+		{"latency:", p.Latency},
+	}
+}
+
+func init() {
+	// Ping
+
+	rootCli.AddCommand(pingCli)
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Plugins are executables named clh-<command> on PATH, `clh foo` runs clh-foo
+const pluginPrefix = "clh-"
+
+// runPlugin runs the plugin named by the first command of args when it is no built-in one,
+// clh flags before it are applied and the rest is passed on. It tells whether a plugin ran.
+func runPlugin(args []string) (bool, error) {
+	clhArgs, name, pluginArgs := splitPluginArgs(args)
+	// help is only added by cobra while executing
+	if name == "" || name == "help" {
+		return false, nil
+	}
+	if cmd, _, err := rootCli.Find(args); err == nil && cmd != rootCli {
+		return false, nil
+	}
+	binary, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return false, nil
+	}
+
+	// Same settings as a built-in command would get
+	if err := rootCli.ParseFlags(clhArgs); err != nil {
+		return true, err
+	}
+	cobraSecondPhase()
+
+	log.Debug("Running plugin ", binary)
+	cmd := exec.Command(binary, pluginArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), pluginEnv()...)
+	if err := cmd.Start(); err != nil {
+		return true, fmt.Errorf("can't run plugin %s: %v", binary, err)
+	}
+
+	// Ctrl-C reaches the plugin through the terminal, a plain kill has to be passed on
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return true, fmt.Errorf("plugin %s failed: %w", name, err)
+	}
+	return true, nil
+}
+
+// pluginEnv passes the resolved config to plugins, secrets are not part of it:
+// a plugin can run `clh config get` for them
+func pluginEnv() []string {
+	context := viper.GetString("context")
+	env := []string{
+		"CLH_CONTEXT=" + context,
+		"CLH_PLUGIN_ENDPOINT=" + contextEndpoints(context)[0],
+		"CLH_PLUGIN_USERNAME=" + viper.GetString(context+".username"),
+		"CLH_PLUGIN_OUTPUT=" + outputFormat(),
+	}
+	// Stdin is already read, the plugin can't get the config that way
+	if fileName := viper.GetString("config"); fileName != stdinConfigName && !noConfig() {
+		env = append(env, "CLH_CONFIG="+fileName)
+	}
+	return env
+}
+
+// splitPluginArgs splits args around the first one which is no flag or flag value,
+// e.g. `-c prod foo --bar` into [-c prod], foo and [--bar]
+func splitPluginArgs(args []string) ([]string, string, []string) {
+	flags := rootCli.PersistentFlags()
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return nil, "", nil
+		case strings.HasPrefix(arg, "--"):
+			flag := flags.Lookup(strings.TrimPrefix(arg, "--"))
+			if !strings.Contains(arg, "=") && flag != nil && flag.NoOptDefVal == "" {
+				i++
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) == 2:
+			flag := flags.ShorthandLookup(arg[1:])
+			if flag != nil && flag.NoOptDefVal == "" {
+				i++
+			}
+		case strings.HasPrefix(arg, "-"):
+			// -vv or -cprod, the value if any is attached
+		default:
+			return args[:i], arg, args[i+1:]
+		}
+	}
+	return nil, "", nil
+}
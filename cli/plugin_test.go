@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePlugin puts a clh-<name> script on PATH of e, it prints its args and the env of clh
+func (e *testEnv) writePlugin(name string) {
+	e.t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		e.t.Skip("sh not installed, plugins not run")
+	}
+	dir := filepath.Join(e.home, "bin")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		e.t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"args: $*\"\nenv | grep '^CLH_' | sort\nexit ${PLUGIN_EXIT:-0}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, pluginPrefix+name), []byte(script), 0700); err != nil {
+		e.t.Fatal(err)
+	}
+	e.env = append(e.env, "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestPlugin(t *testing.T) {
+	e := newTestEnv(t)
+	e.writePlugin("hello")
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: dev\nprod:\n  endpoint: https://prod.example.com/\n  username: alice\n  secret_key: s3cret\n", schemaVersion))
+
+	result := e.mustRun("-c", "prod", "-o", "json", "hello", "world", "--loud")
+	for _, wanted := range []string{"args: world --loud\n", "CLH_CONTEXT=prod\n", "CLH_PLUGIN_ENDPOINT=https://prod.example.com/\n",
+		"CLH_PLUGIN_USERNAME=alice\n", "CLH_PLUGIN_OUTPUT=json\n", "CLH_CONFIG=" + e.configFile() + "\n"} {
+		if !strings.Contains(result.stdout, wanted) {
+			t.Errorf("plugin output misses %q:\n%s", wanted, result.stdout)
+		}
+	}
+	if strings.Contains(result.stdout, "s3cret") {
+		t.Errorf("secret key passed to plugin:\n%s", result.stdout)
+	}
+}
+
+func TestPluginExitCode(t *testing.T) {
+	e := newTestEnv(t)
+	e.writePlugin("hello")
+	e.env = append(e.env, "PLUGIN_EXIT=7")
+
+	if result := e.run("hello"); result.code != 7 {
+		t.Errorf("exit code %d, expected 7 of the plugin: %s", result.code, result.stderr)
+	}
+}
+
+func TestPluginBuiltinWins(t *testing.T) {
+	e := newTestEnv(t)
+	e.writePlugin("version")
+
+	if result := e.mustRun("version", "--short"); strings.Contains(result.stdout, "args:") {
+		t.Errorf("plugin run instead of built-in command: %q", result.stdout)
+	}
+	if result := e.run("nosuchplugin"); result.code == 0 || !strings.Contains(result.logs(), "unknown command") {
+		t.Errorf("exit code %d for an unknown command: %s", result.code, result.stderr)
+	}
+}
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var configProfileCli = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage config profiles",
+	Long:  "List and switch whole config files kept under ~/.clh/profiles, e.g. one per organization",
+	Args:  subcommandArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var configProfileListCli = &cobra.Command{
+	Use:   "list",
+	Short: "List config profiles",
+	Long:  "List profiles found under ~/.clh/profiles, current one is marked with an asterisk",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names, err := profileNames()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			printResult("No profiles yet, use `clh --config-profile <name> config init` to create one")
+			return nil
+		}
+
+		current := profileName()
+		for _, name := range names {
+			if name == current {
+				printResult("* " + name)
+			} else {
+				printResult("  " + name)
+			}
+		}
+		return nil
+	},
+}
+
+var configProfileUseCli = &cobra.Command{
+	Use:   "use [name]",
+	Short: "Switch to another config profile and save it as default",
+	Long:  "Use the config of a profile from now on, without a name the standard config files are used again",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stateFile := profileStateFile()
+		if stateFile == "" {
+			return fmt.Errorf("%w: no home directory for profiles", ErrConfigWrite)
+		}
+		if err := checkWritable(); err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("%w %s: %v", ErrConfigWrite, stateFile, err)
+			}
+			printResult("Using standard config files")
+			return nil
+		}
+
+		name := args[0]
+		fileName, err := profileFile(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			log.Warn("Profile ", name, " has no config yet, `clh config init` creates it")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+			return fmt.Errorf("%w %s: %v", ErrConfigWrite, stateFile, err)
+		}
+		write := func(path string) error {
+			return ioutil.WriteFile(path, []byte(name+"\n"), 0600)
+		}
+		if err := writeFileAtomic(stateFile, write); err != nil {
+			return err
+		}
+		printResult("Using profile " + name)
+		return nil
+	},
+}
+
+func init() {
+	// Config Profile
+
+	configProfileCli.AddCommand(configProfileListCli)
+
+	configProfileCli.AddCommand(configProfileUseCli)
+
+	configCli.AddCommand(configProfileCli)
+}
+
+// profilesDir holds one config file per profile, there are none without a home
+func profilesDir() string {
+	if dir := configDir(); dir != "" {
+		return dir + "/profiles"
+	}
+	if home == "" {
+		return ""
+	}
+	return home + "/.clh/profiles"
+}
+
+// profileStateFile keeps the profile chosen by `clh config profile use`
+func profileStateFile() string {
+	if dir := configDir(); dir != "" {
+		return dir + "/profile"
+	}
+	if home == "" {
+		return ""
+	}
+	return home + "/.clh/profile"
+}
+
+// profileName returns the profile from --config-profile, CLH_CONFIG_PROFILE or
+// the saved one, empty means the standard config files
+func profileName() string {
+	if name, _ := rootCli.PersistentFlags().GetString("config-profile"); name != "" {
+		return name
+	}
+	if name := argValue("config-profile"); name != "" {
+		return name
+	}
+	if name := os.Getenv("CLH_CONFIG_PROFILE"); name != "" {
+		return name
+	}
+	stateFile := profileStateFile()
+	if stateFile == "" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// profileFile returns the config file of a profile, names can't point outside profilesDir
+func profileFile(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	dir := profilesDir()
+	if dir == "" {
+		return "", fmt.Errorf("no home directory for profile %q", name)
+	}
+	return dir + "/" + name + ".yaml", nil
+}
+
+// profileConfig returns the config file of the current profile, if any
+func profileConfig() string {
+	name := profileName()
+	if name == "" {
+		return ""
+	}
+	fileName, err := profileFile(name)
+	if err != nil {
+		log.Warn("Ignoring config profile: ", err)
+		return ""
+	}
+	return fileName
+}
+
+// profileInUse tells if the config is the one of a profile, --config and CLH_CONFIG
+// win over it. Like configDir args are checked for the first phase.
+func profileInUse() bool {
+	if os.Getenv("CLH_CONFIG") != "" || argValue("config") != "" {
+		return false
+	}
+	return profileConfig() != ""
+}
+
+// profileNames returns sorted names of profiles with a config file
+func profileNames() ([]string, error) {
+	dir := profilesDir()
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("can't list profiles: %v", err)
+	}
+
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".yaml" {
+			names = append(names, strings.TrimSuffix(file.Name(), ".yaml"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
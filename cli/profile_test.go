@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// profileEnv has a standard config with context work and a profile org of its own
+func profileEnv(t *testing.T) *testEnv {
+	t.Helper()
+	e := newTestEnv(t)
+	e.writeConfig(fmt.Sprintf("schema_version: %d\ncontext: work\nwork:\n  username: alice\n  secret_key: topsecret\n", schemaVersion))
+	dir := filepath.Join(e.home, ".clh", "profiles")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	profile := fmt.Sprintf("schema_version: %d\ncontext: org\norg:\n  username: bob\n", schemaVersion)
+	if err := ioutil.WriteFile(filepath.Join(dir, "org.yaml"), []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestProfileReplacesStandardConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(e *testEnv)
+		args  []string
+	}{
+		{"flag", func(e *testEnv) {}, []string{"--config-profile", "org"}},
+		{"env", func(e *testEnv) { e.env = append(e.env, "CLH_CONFIG_PROFILE=org") }, nil},
+		{"saved", func(e *testEnv) { e.mustRun("config", "profile", "use", "org") }, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := profileEnv(t)
+			tc.setup(e)
+			run := func(args ...string) runResult {
+				return e.run(append(tc.args, args...)...)
+			}
+
+			if list := run("context", "list").stdout; strings.Contains(list, "work") || !strings.Contains(list, "org") {
+				t.Errorf("contexts of the standard config listed under a profile: %q", list)
+			}
+			if show := run("context", "show", "-c", "work", "-o", "json").stdout; strings.Contains(show, "alice") || strings.Contains(show, "*") {
+				t.Errorf("context of the standard config shown under a profile: %q", show)
+			}
+			for _, key := range []string{"work.secret_key", "work.username"} {
+				if result := run("config", "get", key, "--show-secrets"); result.code == 0 {
+					t.Errorf("%s of the standard config read under a profile: %q", key, result.stdout)
+				}
+			}
+			if current := run("context", "current").stdout; current != "org\n" {
+				t.Errorf("current context is %q, expected org", current)
+			}
+		})
+	}
+}
+
+func TestProfileSavesToProfile(t *testing.T) {
+	e := profileEnv(t)
+	standard := e.readConfig()
+
+	e.mustRun("--config-profile", "org", "config", "set", "org.username", "carol")
+	if e.readConfig() != standard {
+		t.Errorf("standard config changed by a profile:\n%s", e.readConfig())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(e.home, ".clh", "profiles", "org.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := string(data); !strings.Contains(config, "username: carol") || strings.Contains(config, "topsecret") {
+		t.Errorf("unexpected profile config:\n%s", config)
+	}
+}
+
+func TestConfigOverridesProfile(t *testing.T) {
+	e := profileEnv(t)
+	e.env = append(e.env, "CLH_CONFIG_PROFILE=org")
+
+	if current := e.mustRun("--config", e.configFile(), "context", "current").stdout; current != "work\n" {
+		t.Errorf("current context is %q, expected work of --config", current)
+	}
+}
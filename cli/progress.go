@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Delay between spinner frames
+const spinnerInterval = 100 * time.Millisecond
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// progress reports a long operation: a spinner on a terminal, otherwise
+// a log line per update. Nothing is shown when info logs are off, e.g. for --quiet.
+type progress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	color   bool
+	message string
+	// Redraws are skipped until then, so a log line isn't drawn over
+	pause   time.Time
+	done    chan struct{}
+	stopped chan struct{}
+	hooks   log.LevelHooks
+}
+
+// startProgress starts reporting an operation, stop has to be called once it is over
+func startProgress(message string) *progress {
+	p := &progress{}
+	if !log.IsLevelEnabled(log.InfoLevel) {
+		return p
+	}
+	if !spinnerAllowed() {
+		p.update(message)
+		return p
+	}
+	return startSpinner(logStream(), message, !noColor() && viper.GetString("log_color") != "never")
+}
+
+// startSpinner draws message with a spinner to out until stop is called
+func startSpinner(out io.Writer, message string, color bool) *progress {
+	p := &progress{out: out, color: color, message: message, done: make(chan struct{}), stopped: make(chan struct{})}
+	// Logs while spinning get a line of their own
+	hooks := log.LevelHooks{}
+	for level, levelHooks := range log.StandardLogger().Hooks {
+		hooks[level] = append(hooks[level], levelHooks...)
+	}
+	hooks.Add(p)
+	p.hooks = log.StandardLogger().ReplaceHooks(hooks)
+	go p.spin()
+	return p
+}
+
+// spinnerAllowed tells if results and logs both go to a terminal, a spinner
+// in a file or pipe is just noise. JSON logs are for machines, they get no spinner.
+func spinnerAllowed() bool {
+	if logFile != nil || viper.GetString("log_format") == "json" {
+		return false
+	}
+	return terminal.IsTerminal(int(os.Stdout.Fd())) && terminal.IsTerminal(int(logStream().Fd()))
+}
+
+// update replaces the message, without a spinner it is logged
+func (p *progress) update(message string) {
+	if !log.IsLevelEnabled(log.InfoLevel) {
+		return
+	}
+	if p.done == nil {
+		log.Info(message)
+		return
+	}
+	p.mu.Lock()
+	p.message = message
+	p.mu.Unlock()
+}
+
+// stop clears the spinner, logs after it are shown as usual
+func (p *progress) stop() {
+	if p.done == nil {
+		return
+	}
+	close(p.done)
+	<-p.stopped
+	log.StandardLogger().ReplaceHooks(p.hooks)
+	p.done = nil
+}
+
+func (p *progress) spin() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		p.mu.Lock()
+		if time.Now().After(p.pause) {
+			p.draw(spinnerFrames[frame%len(spinnerFrames)])
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			p.mu.Lock()
+			p.clear()
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (p *progress) draw(frame string) {
+	if p.color {
+		frame = "\x1b[36m" + frame + "\x1b[0m"
+	}
+	fmt.Fprintf(p.out, "\r\x1b[K%s %s", frame, p.message)
+}
+
+func (p *progress) clear() {
+	fmt.Fprint(p.out, "\r\x1b[K")
+}
+
+// Levels makes progress a logrus hook for every level
+func (p *progress) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire clears the spinner before a log line is written
+func (p *progress) Fire(entry *log.Entry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.pause = time.Now().Add(spinnerInterval)
+	return nil
+}
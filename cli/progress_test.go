@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSpinner(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	hooks := len(log.StandardLogger().Hooks[log.InfoLevel])
+
+	var out bytes.Buffer
+	p := startSpinner(&out, "Operation op-1 is running, waiting", false)
+	time.Sleep(3 * spinnerInterval)
+	log.Info("a line of its own")
+	p.update("Operation op-1 is still running, waiting")
+	time.Sleep(3 * spinnerInterval)
+	p.stop()
+
+	drawn := out.String()
+	for _, wanted := range []string{"| Operation op-1 is running", "Operation op-1 is still running"} {
+		if !strings.Contains(drawn, wanted) {
+			t.Errorf("spinner didn't draw %q: %q", wanted, drawn)
+		}
+	}
+	if strings.Contains(drawn, "\x1b[36m") {
+		t.Errorf("spinner colored without color: %q", drawn)
+	}
+	if !strings.HasSuffix(drawn, "\r\x1b[K") {
+		t.Errorf("spinner not cleared once stopped: %q", drawn)
+	}
+	if n := len(log.StandardLogger().Hooks[log.InfoLevel]); n != hooks {
+		t.Errorf("%d log hooks left after stop, expected %d", n, hooks)
+	}
+}
+
+// Output of tests is no terminal, waiting is logged a line per poll
+func TestProgressWithoutTerminal(t *testing.T) {
+	t.Parallel()
+	hub := deployHub(t, "succeeded")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("deploy", "shop", "--wait")
+	if !strings.Contains(result.stderr, "Operation op-1 is running, waiting") {
+		t.Errorf("waiting not logged: %q", result.stderr)
+	}
+	if strings.Contains(result.stdout+result.stderr, "\r") {
+		t.Errorf("spinner drawn without a terminal: %q", result.stdout+result.stderr)
+	}
+}
+
+func TestProgressQuiet(t *testing.T) {
+	t.Parallel()
+	hub := deployHub(t, "succeeded")
+	e := newTestEnv(t)
+	e.useHub("local", hub.endpoint())
+
+	result := e.mustRun("deploy", "shop", "--wait", "--quiet")
+	if result.stderr != "" {
+		t.Errorf("progress shown with --quiet: %q", result.stderr)
+	}
+	if !strings.Contains(result.stdout, "succeeded") {
+		t.Errorf("result hidden by --quiet: %q", result.stdout)
+	}
+}
@@ -1,27 +1,98 @@
 package cli
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Build metadata, set with -ldflags "-X clh-cli/cli.version=..."
+var (
+	version   = "v0.1"
+	commit    = "HEAD"
+	buildDate = "unknown"
 )
 
 var home string
 
+// Log file opened for --log_file, closed by logrus exit handler
+var logFile *os.File
+
+// Why log_file couldn't be opened by the last setupLog
+var logFileErr error
+
+// Canceled by Execute on SIGINT or SIGTERM, API requests and helpers stop with it
+var rootCtx = context.Background()
+
+// Standard config files, merged in this order so later ones override earlier
+var configPaths []string
+
+// Config files actually read, in merge order
+var mergedConfigs []string
+
+// --config - reads the config from stdin, kept to be read again like a file
+const stdinConfigName = "-"
+
+var (
+	stdinConfig     []byte
+	stdinConfigType string
+)
+
+// Maps keys like my-ctx.endpoint to env names like CLH_MY_CTX_ENDPOINT
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// Settings read from config files only, without env, flags and defaults
+var fileConfig = viper.New()
+
+// configChange is a single edit made by a command, applied to the file as it is on disk
+type configChange struct {
+	key   string
+	value interface{}
+	unset bool
+}
+
+// Saving viper.AllSettings would bake env, flags and defaults into the file,
+// so only edits tracked here are saved. Viper can't delete keys either.
+var configChanges []configChange
+
+// Timestamped config backups kept by --backup, unless backups is set
+const defaultBackups = 5
+
+// Typos up to this many edits away from a command get a suggestion
+const suggestionsDistance = 2
+
 var rootCli = &cobra.Command{
 	Use:   "clh",
 	Short: "clh is a CloudletHub CLI tool",
 	Long: `CloudletHub is a Continous Delivery as a Service,
 		the only CD you ever need.
 		Complete documentation is available at https://cloudlethub.com/docs`,
-	Run: func(cmd *cobra.Command, args []string) {
+	SilenceErrors:              true,
+	SilenceUsage:               true,
+	SuggestionsMinimumDistance: suggestionsDistance,
+	Args:                       subcommandArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.Help()
-		os.Exit(1)
+		return errors.New("no command given")
 	},
 }
 
@@ -29,29 +100,70 @@ var versionCli = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number of clh",
 	Long:  "All software has versions. We have it too",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("clh v0.1 -- HEAD")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info := buildInfo()
+		// Being offline is no reason to fail, current version is printed anyway
+		if check, _ := cmd.Flags().GetBool("check"); check {
+			if latest, err := latestVersion(); err != nil {
+				log.Warn("Can't check for a newer version: ", err)
+			} else {
+				info.Latest = latest
+				info.Outdated = newerVersion(latest, info.Version)
+			}
+		}
+
+		// Scripts asking for json, yaml or a template get the whole struct, --short is for humans
+		template, _ := rootCli.PersistentFlags().GetString("format-template")
+		if short, _ := cmd.Flags().GetBool("short"); short && outputFormat() == "table" && template == "" {
+			printResult(info.Version)
+			if info.Outdated {
+				log.Info("Newer version ", info.Latest, " is available")
+			}
+			return nil
+		}
+		return render(info)
 	},
 }
 
 var useContextCli = &cobra.Command{
-	Use:   "use-context",
-	Short: "Switch to another context and save it as default",
-	Long:  "Use provided context as default",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) > 0 {
-			viper.Set("context", args[0])
-		}
-		saveConfig()
-	},
+	Use:        "use-context",
+	Short:      "Switch to another context and save it as default",
+	Long:       "Use provided context as default",
+	Deprecated: "use `clh context use` instead",
+	RunE:       useContext,
 }
 
 var configCli = &cobra.Command{
 	Use:   "config",
 	Short: "Configure clh",
 	Long:  `Helps configuring clh tool such as Hub address and credentials`,
-	Run: func(cmd *cobra.Command, args []string) {
-		saveConfig()
+	Args:  subcommandArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		context := viper.GetString("context")
+		// Nothing is asked for when it can't be saved anyway
+		if _, err := configSaveFile(); err != nil {
+			return err
+		}
+		if err := readSecretKey(context); err != nil {
+			return err
+		}
+		if noInteractive, _ := cmd.Flags().GetBool("no-interactive"); !noInteractive {
+			if err := promptCredentials(context); err != nil {
+				return err
+			}
+		}
+		if err := validateEndpoint(viper.GetString(context + ".endpoint")); err != nil {
+			return err
+		}
+
+		// Endpoint is saved for a new context even if it is the default one
+		for _, key := range []string{"endpoint", "username", "secret_key"} {
+			changed := cmd.Flags().Changed(key) || key == "secret_key" && cmd.Flags().Changed("secret_key-file")
+			if changed || key == "endpoint" && !fileConfig.IsSet(context+".endpoint") {
+				setConfig(context+"."+key, viper.GetString(context+"."+key))
+			}
+		}
+		return saveConfig()
 	},
 }
 
@@ -62,31 +174,113 @@ func init() {
 	viper.BindPFlag("log_level", rootCli.PersistentFlags().Lookup("log_level"))
 	viper.SetDefault("log_level", "info")
 
-	rootCli.PersistentFlags().StringP("config", "", "", "Path to a config file")
+	rootCli.PersistentFlags().BoolP("quiet", "q", false, "Log errors only, overrides log_level")
+
+	rootCli.PersistentFlags().CountP("verbose", "v", "Log debug, -vv for trace, overrides a less verbose log_level")
+
+	rootCli.PersistentFlags().StringP("log_format", "", "", "Format for logs: text or json")
+	viper.BindPFlag("log_format", rootCli.PersistentFlags().Lookup("log_format"))
+	viper.SetDefault("log_format", "text")
+
+	rootCli.PersistentFlags().StringP("log_color", "", "", "Colors in text logs: auto, always or never")
+	viper.BindPFlag("log_color", rootCli.PersistentFlags().Lookup("log_color"))
+	viper.SetDefault("log_color", "auto")
+
+	rootCli.PersistentFlags().BoolP("no-color", "", false, "Disable colors, same as log_color never or NO_COLOR")
+
+	rootCli.PersistentFlags().StringP("log_file", "", "", "Append logs to a file instead of stderr")
+	viper.BindPFlag("log_file", rootCli.PersistentFlags().Lookup("log_file"))
+
+	// Stdout is for results, logs used to go there too
+	rootCli.PersistentFlags().BoolP("log_stdout", "", false, "Log to stdout like older versions instead of stderr")
+	viper.BindPFlag("log_stdout", rootCli.PersistentFlags().Lookup("log_stdout"))
+	log.RegisterExitHandler(closeLogFile)
+
+	rootCli.PersistentFlags().StringP("config", "", "", "Path to a config file, overrides CLH_CONFIG")
 	viper.BindPFlag("config", rootCli.PersistentFlags().Lookup("config"))
 
+	rootCli.PersistentFlags().StringP("config-type", "", "yaml", "Format of the config read with --config -: yaml, json or toml")
+
+	rootCli.PersistentFlags().StringP("save-to", "", "", "File to save the config to when it was read with --config -")
+
+	rootCli.PersistentFlags().StringP("config-dir", "", "", "Only directory to search and save config.yaml in, overrides CLH_CONFIG_DIR")
+
+	rootCli.PersistentFlags().StringP("config-profile", "", "", "Config profile from ~/.clh/profiles, overrides CLH_CONFIG_PROFILE")
+
 	rootCli.PersistentFlags().StringP("context", "c", "", "CLH context name")
 	viper.BindPFlag("context", rootCli.PersistentFlags().Lookup("context"))
 	viper.SetDefault("context", "default")
 
+	rootCli.PersistentFlags().DurationP("timeout", "", 0, "Timeout for API requests, e.g. 1m")
+	viper.BindPFlag("timeout", rootCli.PersistentFlags().Lookup("timeout"))
+	viper.SetDefault("timeout", defaultTimeout.String())
+
+	rootCli.PersistentFlags().StringP("proxy", "", "", "Proxy for API requests, overrides HTTP(S)_PROXY")
+	viper.BindPFlag("proxy", rootCli.PersistentFlags().Lookup("proxy"))
+
+	rootCli.PersistentFlags().BoolP("insecure", "", false, "Skip TLS certificate verification")
+	viper.BindPFlag("insecure", rootCli.PersistentFlags().Lookup("insecure"))
+
+	rootCli.PersistentFlags().StringP("cacert", "", "", "PEM file with CA certificates to trust")
+	viper.BindPFlag("cacert", rootCli.PersistentFlags().Lookup("cacert"))
+
+	rootCli.PersistentFlags().IntP("retries", "", 0, "Retries of failed idempotent API requests")
+	viper.BindPFlag("retries", rootCli.PersistentFlags().Lookup("retries"))
+
+	rootCli.PersistentFlags().BoolP("retry_unsafe", "", false, "Retry non idempotent API requests too")
+	viper.BindPFlag("retry_unsafe", rootCli.PersistentFlags().Lookup("retry_unsafe"))
+
+	// Context overrides, bound to keys of the context in viperSecondPhase
+	// and only saved by `clh config`
+
+	rootCli.PersistentFlags().StringP("endpoint", "e", "", "CLH address")
+
+	rootCli.PersistentFlags().StringP("username", "u", "", "CLH username")
+
+	rootCli.PersistentFlags().StringP("secret_key", "k", "", "CLH Secret Key ID, - reads it from stdin")
+
+	rootCli.PersistentFlags().StringP("secret_key-file", "", "", "Read CLH Secret Key ID from a file")
+
+	// Per run switch, not a setting, so it is never saved
+	rootCli.PersistentFlags().BoolP("dry-run", "", false, "Print the config instead of saving it")
+
+	rootCli.PersistentFlags().BoolP("no-config", "", false, "Ignore config files, use env and flags only")
+
+	rootCli.PersistentFlags().BoolP("read-only", "", false, "Fail any command which would change config files")
+
+	rootCli.PersistentFlags().BoolP("no-rewrite", "", false, "Don't save over yaml configs with anchors or comments, saving would drop them")
+
+	rootCli.PersistentFlags().BoolP("backup", "", false, "Keep a timestamped backup of the config instead of <config>.bak")
+	viper.SetDefault("backups", defaultBackups)
+
+	rootCli.PersistentFlags().StringP("output", "o", "", "Output format: table, json or yaml")
+	viper.BindPFlag("output", rootCli.PersistentFlags().Lookup("output"))
+	viper.SetDefault("output", "table")
+
+	rootCli.PersistentFlags().StringP("output-file", "", "", "Write the result to a file instead of stdout, logs are not included")
+
+	rootCli.PersistentFlags().StringP("format-template", "", "", "Go template for results, e.g. '{{.Version}}', overrides output")
+
+	viper.SetDefault("default_endpoint", defaultEndpoint)
+
 	// When root core arguments is defined - read environment and configs
 	viperFirstPhase()
 
 	// Version
 
+	versionCli.Flags().BoolP("short", "", false, "Print only the version")
+	versionCli.Flags().BoolP("check", "", false, "Check update_url for a newer release")
+	viper.SetDefault("update_url", defaultUpdateURL)
 	rootCli.AddCommand(versionCli)
 
 	// Use Context
 
+	useContextCli.Flags().BoolP("create", "", false, "Switch even if the context is not configured yet")
 	rootCli.AddCommand(useContextCli)
 
 	// Config
 
-	configCli.PersistentFlags().StringP("endpoint", "e", "", "CLH address")
-
-	configCli.PersistentFlags().StringP("username", "u", "", "CLH username")
-
-	configCli.PersistentFlags().StringP("secret_key", "k", "", "CLH Secret Key ID")
+	configCli.Flags().BoolP("no-interactive", "", false, "Never prompt for missing credentials")
 
 	rootCli.AddCommand(configCli)
 
@@ -94,104 +288,921 @@ func init() {
 	cobra.OnInitialize(cobraSecondPhase)
 }
 
+// viperFirstPhase reads env and standard config files, a config that can't be read is
+// logged and skipped so commands like `clh config init --force` can still fix it
 func viperFirstPhase() {
 	viper.SetEnvPrefix("CLH")
+	viper.SetEnvKeyReplacer(envKeyReplacer)
 	viper.AutomaticEnv()
 
 	// First: at least consider environment variables
-	setLogLevel()
+	setupLog()
 
-	h, err := homedir.Dir()
-	if err != nil {
-		log.Panic(err)
-		os.Exit(1)
+	// Commands like version work without a home, there is just no ~/.clh
+	if h, err := homedir.Dir(); err != nil {
+		log.Warn("Can't find home directory, skipping ~/.clh: ", err)
+	} else {
+		home = h
 	}
-	home = h
 
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("/etc/clh/")
-	viper.AddConfigPath(home + "/.clh")
-	viper.AddConfigPath("./.clh")
-	viper.SetConfigName("config")
+	// A profile is a whole config of its own, standard files are not read with it
+	if profileInUse() {
+		configPaths = nil
+	} else if dir := configDir(); dir != "" {
+		configPaths = []string{dir + "/config.yaml"}
+	} else {
+		configPaths = []string{"/etc/clh/config.yaml"}
+		if home != "" {
+			configPaths = append(configPaths, home+"/.clh/config.yaml")
+		}
+		// XDG location is preferred, ~/.clh keeps working for existing setups
+		if xdg := xdgConfigHome(); xdg != "" {
+			configPaths = append(configPaths, xdg+"/clh/config.yaml")
+		}
+		configPaths = append(configPaths, "./.clh/config.yaml")
+	}
+	for _, path := range configPaths {
+		logConfigError(mergeConfig(path))
+	}
 
-	if err := viper.MergeInConfig(); err != nil {
-		log.Debug("Can't read config: ", err)
+	// CLH_CONFIG is known before flags are parsed, --config still overrides it later.
+	// Stdin is only read once --config-type is known.
+	if cfgFile := os.Getenv("CLH_CONFIG"); cfgFile != "" && cfgFile != stdinConfigName {
+		logConfigError(mergeConfig(resolvePath(cfgFile)))
 	}
 
 	// Second: + standard config files
-	setLogLevel()
+	setupLog()
 }
 
 func cobraSecondPhase() {
 	// Third: + cli
-	setLogLevel()
+	setupLog()
 
-	cfgFile := viper.GetString("config")
-	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
-	}
-
-	if err := viper.MergeInConfig(); err != nil {
-		log.Debug("Can't read config: ", err)
+	if cfgFile := configOverride(); cfgFile == stdinConfigName {
+		viper.Set("config", cfgFile)
+		logConfigError(mergeStdinConfig())
+	} else if cfgFile != "" {
+		// Same resolved path is used to read and to save
+		cfgFile = resolvePath(cfgFile)
+		viper.Set("config", cfgFile)
+		logConfigError(mergeConfig(cfgFile))
 	}
 
 	// Forth: + custom config file
-	setLogLevel()
+	setupLog()
+
+	for _, path := range mergedConfigs {
+		warnUnsafeConfig(path)
+	}
 
 	// Bind and set defaults AFTER cobra is ready
 	viperSecondPhase()
+
+	warnLogSettings()
 }
 
 func viperSecondPhase() {
-	context := viper.GetString("context")
+	// Viper keys are case insensitive, context names from -c, CLH_CONTEXT and files are too
+	context := strings.ToLower(strings.TrimSpace(viper.GetString("context")))
+	viper.Set("context", context)
 
 	// Root
 
-	if viper.ConfigFileUsed() != "" {
-		viper.SetDefault("config", viper.ConfigFileUsed())
-	} else {
-		viper.SetDefault("config", home+"/.clh/config.yaml")
+	// Set rather than defaulted, so a config key saved in the file by schema 0 is ignored
+	if configOverride() == "" {
+		if configFileUsed() != "" {
+			viper.Set("config", configFileUsed())
+		} else {
+			viper.Set("config", userConfigFile())
+		}
 	}
 
 	// Config
 
-	viper.BindPFlag(context+".endpoint", configCli.PersistentFlags().Lookup("endpoint"))
-	viper.SetDefault(context+".endpoint", "https://api.cloudlethub.com/")
+	viper.BindPFlag(context+".endpoint", rootCli.PersistentFlags().Lookup("endpoint"))
+
+	viper.BindPFlag(context+".username", rootCli.PersistentFlags().Lookup("username"))
+
+	viper.BindPFlag(context+".secret_key", rootCli.PersistentFlags().Lookup("secret_key"))
+
+	// Output
+
+	// --output wins over output of the context, which wins over the top level one
+	viper.BindPFlag(context+".output", rootCli.PersistentFlags().Lookup("output"))
+
+	for _, key := range []string{"endpoint", "username", "secret_key"} {
+		warnEnvOverride(context + "." + key)
+	}
+
+	expandConfigEnv()
+
+	// Self-hosted hubs set default_endpoint once instead of on every context
+	viper.SetDefault(context+".endpoint", viper.GetString("default_endpoint"))
+
+	viper.SetDefault(context+".output", viper.GetString("output"))
+
+	// Only commands talking to the API really need a valid context
+	if err := validateContext(); err != nil {
+		log.Debug(err)
+	}
+}
+
+type versionInfo struct {
+	Version   string `json:"version" yaml:"version"`
+	Commit    string `json:"commit" yaml:"commit"`
+	Date      string `json:"date" yaml:"date"`
+	GoVersion string `json:"go_version" yaml:"go_version"`
+	Latest    string `json:"latest,omitempty" yaml:"latest,omitempty"`
+	Outdated  bool   `json:"outdated,omitempty" yaml:"outdated,omitempty"`
+}
+
+// buildInfo returns the metadata set with -ldflags, without them (commit is still HEAD)
+// it falls back to what the go tool recorded, e.g. the module version for go install
+func buildInfo() versionInfo {
+	info := versionInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      buildDate,
+		GoVersion: runtime.Version(),
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok || commit != "HEAD" {
+		return info
+	}
+
+	if bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.Date = setting.Value
+		}
+	}
+	return info
+}
+
+func (v versionInfo) rows() [][]string {
+	rows := [][]string{
+		{"version:", v.Version},
+		{"commit:", v.Commit},
+		{"built:", v.Date},
+		{"go:", v.GoVersion},
+	}
+	if v.Latest != "" {
+		status := "up to date"
+		if v.Outdated {
+			status = "update available"
+		}
+		rows = append(rows, []string{"latest:", v.Latest + " (" + status + ")"})
+	}
+	return rows
+}
+
+// configType picks the config format from the file extension, yaml unless told otherwise
+func configType(fileName string) string {
+	switch ext := strings.TrimPrefix(filepath.Ext(fileName), "."); ext {
+	case "json", "toml":
+		return ext
+	default:
+		return "yaml"
+	}
+}
+
+// noConfig tells if config files are ignored for --no-config or CLH_NO_CONFIG,
+// the first phase reads configs before flags are parsed so args are checked as well
+func noConfig() bool {
+	if off, err := strconv.ParseBool(os.Getenv("CLH_NO_CONFIG")); err == nil && off {
+		return true
+	}
+	if off, _ := rootCli.PersistentFlags().GetBool("no-config"); off {
+		return true
+	}
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+		if arg == "--no-config" || arg == "--no-config=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// configDir returns the directory from --config-dir or CLH_CONFIG_DIR which replaces
+// the standard ones, like noConfig args are checked for the first phase
+func configDir() string {
+	dir, _ := rootCli.PersistentFlags().GetString("config-dir")
+	if dir == "" {
+		dir = argValue("config-dir")
+	}
+	if dir == "" {
+		dir = os.Getenv("CLH_CONFIG_DIR")
+	}
+	if dir == "" {
+		return ""
+	}
+	return resolvePath(dir)
+}
+
+// argValue returns the value of a string flag from the command line, for settings
+// needed before flags are parsed
+func argValue(flag string) string {
+	for i, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+flag && i+2 < len(os.Args) {
+			return os.Args[i+2]
+		}
+		if strings.HasPrefix(arg, "--"+flag+"=") {
+			return strings.TrimPrefix(arg, "--"+flag+"=")
+		}
+	}
+	return ""
+}
+
+// checkWritable fails when config files may not be changed for --read-only or CLH_READ_ONLY,
+// e.g. a config mounted read-only in a container
+func checkWritable() error {
+	if off, err := strconv.ParseBool(os.Getenv("CLH_READ_ONLY")); err == nil && off {
+		return errReadOnly
+	}
+	if off, _ := rootCli.PersistentFlags().GetBool("read-only"); off {
+		return errReadOnly
+	}
+	return nil
+}
+
+// noRewrite tells if hand written configs are kept as is for --no-rewrite or CLH_NO_REWRITE
+func noRewrite() bool {
+	if off, err := strconv.ParseBool(os.Getenv("CLH_NO_REWRITE")); err == nil && off {
+		return true
+	}
+	off, _ := rootCli.PersistentFlags().GetBool("no-rewrite")
+	return off
+}
+
+// configOverride returns the config file chosen with --config, CLH_CONFIG or a profile
+func configOverride() string {
+	if cfgFile, _ := rootCli.PersistentFlags().GetString("config"); cfgFile != "" {
+		return cfgFile
+	}
+	if cfgFile := os.Getenv("CLH_CONFIG"); cfgFile != "" {
+		return cfgFile
+	}
+	return profileConfig()
+}
+
+// resolvePath expands ~ and makes a path absolute
+func resolvePath(path string) string {
+	if p, err := homedir.Expand(path); err == nil {
+		path = p
+	}
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	return path
+}
+
+// mergeConfig merges a config file on top of settings read so far, missing files are skipped
+func mergeConfig(path string) error {
+	if noConfig() {
+		return nil
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if inSlice(path, mergedConfigs) {
+		return nil
+	}
+
+	viper.SetConfigFile(path)
+	viper.SetConfigType(configType(path))
+	if err := viper.MergeInConfig(); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrConfigNotFound, path)
+		}
+		return fmt.Errorf("can't read config %s: %v", path, err)
+	}
+	fileConfig.SetConfigFile(path)
+	fileConfig.SetConfigType(configType(path))
+	fileConfig.MergeInConfig()
+	mergedConfigs = append(mergedConfigs, path)
+	return nil
+}
+
+// mergeStdinConfig merges the config piped to stdin for --config -, e.g. from a secrets manager
+func mergeStdinConfig() error {
+	if noConfig() {
+		return nil
+	}
+	typ, _ := rootCli.PersistentFlags().GetString("config-type")
+	if !inSlice(typ, []string{"yaml", "json", "toml"}) {
+		return fmt.Errorf("unknown config type %q, use one of: yaml, json, toml", typ)
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("can't read config from stdin: %v", err)
+	}
+
+	viper.SetConfigType(typ)
+	if err := viper.MergeConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("can't read config from stdin: %v", err)
+	}
+	fileConfig.SetConfigType(typ)
+	fileConfig.MergeConfig(bytes.NewReader(data))
+	stdinConfig, stdinConfigType = data, typ
+	mergedConfigs = append(mergedConfigs, stdinConfigName)
+	return nil
+}
+
+// logConfigError reports a failed merge, missing config files are expected
+func logConfigError(err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrConfigNotFound):
+		log.Debug(err)
+	default:
+		log.Warn(err)
+	}
+}
+
+// warnUnsafeConfig warns about secrets in a config readable by group or others, like ssh does
+func warnUnsafeConfig(fileName string) {
+	if mode, exposed := secretsExposed(fileName); exposed {
+		log.Warnf("Config %s holds secrets but is accessible by others (%04o), run `chmod 600 %s`",
+			fileName, mode, fileName)
+	}
+}
+
+// secretsExposed tells if a config holds secrets and is accessible by group or others
+func secretsExposed(fileName string) (os.FileMode, bool) {
+	info, err := os.Stat(fileName)
+	// Windows has no such permission bits
+	if err != nil || runtime.GOOS == "windows" || info.Mode().Perm()&0077 == 0 {
+		return 0, false
+	}
+	settings, err := readConfigFile(fileName)
+	if err != nil || !hasSecrets(settings) {
+		return 0, false
+	}
+	return info.Mode().Perm(), true
+}
+
+// xdgConfigHome returns $XDG_CONFIG_HOME, relative values are invalid per the spec
+func xdgConfigHome() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdg) {
+		return xdg
+	}
+	return ""
+}
+
+// userConfigFile is where a new config is saved when none was read
+func userConfigFile() string {
+	if dir := configDir(); dir != "" {
+		return dir + "/config.yaml"
+	}
+	if xdg := xdgConfigHome(); xdg != "" {
+		return xdg + "/clh/config.yaml"
+	}
+	if home == "" {
+		return ""
+	}
+	return home + "/.clh/config.yaml"
+}
+
+// configFileUsed returns the config file merged last, it has the highest priority
+func configFileUsed() string {
+	if len(mergedConfigs) == 0 {
+		return ""
+	}
+	return mergedConfigs[len(mergedConfigs)-1]
+}
+
+// expandConfigEnv expands $VAR and ${VAR} in string values read from config files,
+// values overridden by env or flags are left alone. Expanded values are never saved.
+func expandConfigEnv() {
+	for _, key := range fileConfig.AllKeys() {
+		value, ok := fileConfig.Get(key).(string)
+		if !ok || !strings.Contains(value, "$") || viper.GetString(key) != value {
+			continue
+		}
+		viper.Set(key, expandEnv(value))
+	}
+}
+
+// expandEnv is os.ExpandEnv, except $$ stands for a literal $
+func expandEnv(value string) string {
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		return os.Getenv(name)
+	})
+}
+
+// envName returns the environment variable overriding a key
+func envName(key string) string {
+	return "CLH_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
 
-	viper.BindPFlag(context+".username", configCli.PersistentFlags().Lookup("username"))
+// warnEnvOverride tells when an env variable silently wins over the config file
+func warnEnvOverride(key string) {
+	env, ok := os.LookupEnv(envName(key))
+	if !ok || !fileConfig.IsSet(key) || fileConfig.GetString(key) == env {
+		return
+	}
+	log.Warn("Environment variable ", envName(key), " overrides ", key, " from config file")
+}
 
-	viper.BindPFlag(context+".secret_key", configCli.PersistentFlags().Lookup("secret_key"))
+func setupLog() {
+	setLogLevel()
+	setLogFormat()
+	setLogOutput()
 }
 
 func setLogLevel() {
+	// --quiet wins over log_level from anywhere
+	if quiet, _ := rootCli.PersistentFlags().GetBool("quiet"); quiet {
+		log.SetLevel(log.ErrorLevel)
+		return
+	}
+
+	// A wrong log_level is reported once by warnLogSettings
 	ll, err := log.ParseLevel(viper.GetString("log_level"))
 	if err != nil {
+		ll = log.InfoLevel
+	}
+	// -v is debug, -vv is trace, a more verbose log_level is kept
+	verbose, _ := rootCli.PersistentFlags().GetCount("verbose")
+	if verbose > 0 && ll < log.DebugLevel {
 		ll = log.DebugLevel
-		log.Error("Error in log level parsing, fall back to DEBUG: ", err)
+	}
+	if verbose > 1 {
+		ll = log.TraceLevel
 	}
 	log.SetLevel(ll)
 }
 
-func saveConfig() {
+func setLogFormat() {
+	switch format := viper.GetString("log_format"); format {
+	case "text":
+		log.SetFormatter(textFormatter())
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(textFormatter())
+	}
+}
+
+func setLogOutput() {
+	path := viper.GetString("log_file")
+	if logFile != nil && logFile.Name() == path {
+		return
+	}
+	closeLogFile()
+	logFileErr = nil
+	if path == "" {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logFileErr = err
+		return
+	}
+	logFile = f
+	log.SetOutput(f)
+}
+
+// warnLogSettings reports log settings which couldn't be applied, setupLog runs once per
+// phase and leaves it to the end so each is reported once
+func warnLogSettings() {
+	if quiet, _ := rootCli.PersistentFlags().GetBool("quiet"); !quiet {
+		if _, err := log.ParseLevel(viper.GetString("log_level")); err != nil {
+			log.Warn("Error in log level parsing, fall back to INFO: ", err)
+		}
+	}
+	if format := viper.GetString("log_format"); format != "text" && format != "json" {
+		log.Error("Unknown log format, fall back to text: ", format)
+	}
+	if logFileErr != nil {
+		log.Error("Can't open log file, fall back to ", logStream().Name(), ": ", logFileErr)
+	}
+}
+
+// closeLogFile switches logs back to logStream
+func closeLogFile() {
+	log.SetOutput(logStream())
+	if logFile == nil {
+		return
+	}
+	logFile.Sync()
+	logFile.Close()
+	logFile = nil
+}
+
+// logStream is where logs go without log_file
+func logStream() *os.File {
+	if viper.GetBool("log_stdout") {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+func textFormatter() *log.TextFormatter {
+	f := &log.TextFormatter{}
+	if noColor() {
+		f.DisableColors = true
+		return f
+	}
+	switch color := viper.GetString("log_color"); color {
+	case "auto":
+	case "always":
+		f.ForceColors = true
+	case "never":
+		f.DisableColors = true
+	default:
+		log.Error("Unknown log color mode, fall back to auto: ", color)
+	}
+	return f
+}
+
+// noColor tells if colors are off for --no-color, or NO_COLOR (https://no-color.org)
+// unless log_color is always. Anything printing colors has to check it.
+func noColor() bool {
+	if off, _ := rootCli.PersistentFlags().GetBool("no-color"); off {
+		return true
+	}
+	return os.Getenv("NO_COLOR") != "" && viper.GetString("log_color") != "always"
+}
+
+// configSaveFile returns the file saveConfig writes to or why it can't,
+// commands whose changes can't be redone check it before making them
+func configSaveFile() (string, error) {
+	if noConfig() {
+		return "", errNoConfig
+	}
 	fileName := viper.GetString("config")
-	dirName := filepath.Dir(fileName)
+	if fileName == "" {
+		return "", errNoConfigFile
+	}
+	if dryRun, _ := rootCli.PersistentFlags().GetBool("dry-run"); !dryRun {
+		if err := checkWritable(); err != nil {
+			return "", err
+		}
+	}
+	// Stdin can't be written back, the piped config and changes go to --save-to
+	if fileName == stdinConfigName {
+		saveTo, _ := rootCli.PersistentFlags().GetString("save-to")
+		if saveTo == "" {
+			return "", errConfigStdin
+		}
+		fileName = resolvePath(saveTo)
+	}
+	return fileName, nil
+}
+
+func saveConfig() error {
+	fileName, err := configSaveFile()
+	if err != nil {
+		return err
+	}
+	dryRun, _ := rootCli.PersistentFlags().GetBool("dry-run")
+
+	settings, err := readConfigFile(viper.GetString("config"))
+	if err != nil {
+		return err
+	}
+	// A new file is created in the current schema
+	if len(settings) == 0 {
+		settings["schema_version"] = schemaVersion
+	}
+	// Older versions saved the path of the file itself, it breaks the merge order on next read
+	delete(settings, "config")
+	applyChanges(settings, configChanges)
+
+	if dryRun {
+		maskSettings(settings)
+		data, err := yaml.Marshal(settings)
+		if err != nil {
+			return fmt.Errorf("can't render config: %v", err)
+		}
+		printResult("# Dry run, " + fileName + " would be:")
+		fmt.Fprint(resultWriter(), string(data))
+		return nil
+	}
+
+	// Settings are saved, not the text, anything else in a hand written file is lost
+	lost := rewriteLosses(fileName)
+	if len(lost) > 0 && noRewrite() {
+		return fmt.Errorf("%w: %s has %s which saving would drop, edit it by hand or drop --no-rewrite", ErrConfigWrite, fileName, strings.Join(lost, " and "))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrConfigWrite, fileName, err)
+	}
+	if err := backupConfig(fileName); err != nil {
+		return err
+	}
+	v := viper.New()
+	v.MergeConfigMap(settings)
+	if err := writeFileAtomic(fileName, v.WriteConfigAs); err != nil {
+		return err
+	}
+	if len(lost) > 0 {
+		log.Warn("Config ", fileName, " had ", strings.Join(lost, " and "), ", saving dropped them, the previous file is kept as a backup")
+	}
+	return nil
+}
+
+var (
+	// Quoted scalars may hold anything, they are skipped
+	yamlQuoted  = regexp.MustCompile(`"(\\.|[^"\\])*"|'[^']*'`)
+	yamlAnchor  = regexp.MustCompile(`(^|[\s\[{,])[&*][^\s\[\]{},]+|(^|\s)<<\s*:`)
+	yamlComment = regexp.MustCompile(`(^|\s)#`)
+)
+
+// isStarterComment tells if line is a comment line of starterConfig
+func isStarterComment(line string) bool {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "#") {
+		return false
+	}
+	for _, starter := range strings.Split(starterConfig, "\n") {
+		if strings.TrimSpace(starter) == line {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteLosses tells what saving a yaml config drops: anchors, aliases and merge keys
+// are resolved and comments are gone. Other formats have nothing like that clh reads.
+func rewriteLosses(fileName string) []string {
+	if configType(fileName) != "yaml" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+	var anchors, comments bool
+	for _, line := range strings.Split(string(data), "\n") {
+		// Comments of `clh config init` are no work of the user
+		if isStarterComment(line) {
+			continue
+		}
+		line = yamlQuoted.ReplaceAllString(line, `""`)
+		if i := yamlComment.FindStringIndex(line); i != nil {
+			comments = true
+			line = line[:i[0]]
+		}
+		if yamlAnchor.MatchString(line) {
+			anchors = true
+		}
+	}
+	var lost []string
+	if anchors {
+		lost = append(lost, "anchors")
+	}
+	if comments {
+		lost = append(lost, "comments")
+	}
+	return lost
+}
+
+// backupConfig copies the config about to be overwritten to <config>.bak, or with --backup
+// to <config>.<time>.bak keeping the last `backups` of them. A new config has nothing to keep.
+func backupConfig(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't back up config: %v", err)
+	}
+
+	timestamped, _ := rootCli.PersistentFlags().GetBool("backup")
+	backupName := fileName + ".bak"
+	if timestamped {
+		backupName = fileName + "." + time.Now().Format("20060102-150405") + ".bak"
+	}
+	// Backups hold the same credentials, one left before may still have a looser mode
+	if info, err := os.Stat(backupName); err == nil && info.Mode().IsRegular() {
+		if err := os.Remove(backupName); err != nil {
+			return fmt.Errorf("can't back up config: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(backupName, data, privateMode(fileName)); err != nil {
+		return fmt.Errorf("can't back up config: %v", err)
+	}
+	log.Debug("Config backed up to ", backupName)
+
+	if timestamped {
+		pruneBackups(fileName)
+	}
+	return nil
+}
+
+// pruneBackups removes the oldest timestamped backups beyond `backups`, zero keeps all
+func pruneBackups(fileName string) {
+	keep := viper.GetInt("backups")
+	// Timestamps sort by time
+	backups, err := filepath.Glob(fileName + ".*.bak")
+	if err != nil || keep <= 0 || len(backups) <= keep {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-keep] {
+		if err := os.Remove(backup); err != nil {
+			log.Warn("Can't remove old config backup: ", err)
+		}
+	}
+}
+
+// readConfigFile reads a single config file as is, a missing file has no settings
+func readConfigFile(fileName string) (map[string]interface{}, error) {
+	v := viper.New()
+	if fileName == stdinConfigName {
+		v.SetConfigType(stdinConfigType)
+		if err := v.ReadConfig(bytes.NewReader(stdinConfig)); err != nil && stdinConfig != nil {
+			return nil, fmt.Errorf("can't read config from stdin: %v", err)
+		}
+		return v.AllSettings(), nil
+	}
+	v.SetConfigType(configType(fileName))
+	v.SetConfigFile(fileName)
+	if err := v.ReadInConfig(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't read config %s: %v", fileName, err)
+	}
+	return v.AllSettings(), nil
+}
+
+// applyChanges applies edits to settings in order
+func applyChanges(settings map[string]interface{}, changes []configChange) {
+	for _, change := range changes {
+		if change.unset {
+			deleteKey(settings, strings.Split(change.key, "."))
+		} else {
+			setKey(settings, strings.Split(change.key, "."), change.value)
+		}
+	}
+}
+
+// privateMode is the mode to save a config with. Config holds credentials, it is saved
+// private. An existing file keeps its mode only if that is stricter, e.g. 0400, a 0644
+// one is tightened.
+func privateMode(fileName string) os.FileMode {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(fileName); err == nil && info.Mode().Perm()&^mode == 0 {
+		mode = info.Mode().Perm()
+	}
+	return mode
+}
+
+// writeFileAtomic writes a file next to the target and renames it over,
+// so a failed write never leaves the target truncated
+func writeFileAtomic(fileName string, write func(string) error) error {
+	mode := privateMode(fileName)
+
+	// Extension of the temporary file tells viper the format to write
+	ext := filepath.Ext(fileName)
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), "."+strings.TrimSuffix(filepath.Base(fileName), ext)+"-*."+configType(fileName))
+	if err != nil {
+		return fmt.Errorf("%w %s: %v", ErrConfigWrite, fileName, err)
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpName)
+
+	if err := write(tmpName); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrConfigWrite, fileName, err)
+	}
+
+	if err := os.Chmod(tmpName, mode); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrConfigWrite, fileName, err)
+	}
+
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrConfigWrite, fileName, err)
+	}
+
+	return nil
+}
+
+// setDefaultContext switches context for this run and saves it as default,
+// --context and CLH_CONTEXT alone only apply to a single run
+func setDefaultContext(name string) {
+	setConfig("context", name)
+	fileConfig.Set("context", name)
+}
+
+// setConfig sets a key for this run and marks it to be saved
+func setConfig(key string, value interface{}) {
+	viper.Set(key, value)
+	configChanges = append(configChanges, configChange{key: strings.ToLower(key), value: value})
+}
+
+// unsetConfig marks a key (or a whole subtree) to be dropped on save
+func unsetConfig(key string) {
+	configChanges = append(configChanges, configChange{key: strings.ToLower(key), unset: true})
+}
 
-	if err := os.MkdirAll(dirName, os.ModePerm); err != nil {
-		log.Panic("Can't create config directory: ", err)
-		os.Exit(1)
+func getKey(settings map[string]interface{}, path []string) (interface{}, bool) {
+	if len(path) == 1 {
+		value, ok := settings[path[0]]
+		return value, ok
+	}
+	if sub, ok := settings[path[0]].(map[string]interface{}); ok {
+		return getKey(sub, path[1:])
 	}
+	return nil, false
+}
 
-	// TODO: Some stuff needs to be filtered out before saving
-	// Needs: https://github.com/spf13/viper/issues/632
-	if err := viper.WriteConfigAs(fileName); err != nil {
-		log.Panic("Can't save config: ", err)
-		os.Exit(1)
+func setKey(settings map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		settings[path[0]] = value
+		return
 	}
+	sub, ok := settings[path[0]].(map[string]interface{})
+	if !ok {
+		sub = map[string]interface{}{}
+		settings[path[0]] = sub
+	}
+	setKey(sub, path[1:], value)
 }
 
-func Execute() {
-	if err := rootCli.Execute(); err != nil {
-		log.Panic(err)
-		os.Exit(1)
+func deleteKey(settings map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(settings, path[0])
+		return
+	}
+	if sub, ok := settings[path[0]].(map[string]interface{}); ok {
+		deleteKey(sub, path[1:])
+	}
+}
+
+// subcommandArgs rejects positional args of commands grouping subcommands,
+// so a mistyped subcommand fails with a suggestion instead of running the parent
+func subcommandArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd.SuggestionsMinimumDistance = suggestionsDistance
+	suggestions := cmd.SuggestionsFor(args[0])
+	if len(suggestions) == 0 {
+		return fmt.Errorf("unknown command %q for %q, see `%s --help`", args[0], cmd.CommandPath(), cmd.CommandPath())
+	}
+	for i, suggestion := range suggestions {
+		suggestions[i] = strconv.Quote(suggestion)
+	}
+	return fmt.Errorf("unknown command %q for %q, did you mean %s? See `%s --help`",
+		args[0], cmd.CommandPath(), strings.Join(suggestions, " or "), cmd.CommandPath())
+}
+
+// Execute runs the clh command line, errors are left to the caller to report
+func Execute() (err error) {
+	// User errors are returned, a panic is a bug. Its stack is only useful in a bug report.
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debug("Panic: ", r, "\n", string(debug.Stack()))
+			err = fmt.Errorf("internal error: %v, run with -v for details and report it", r)
+		}
+	}()
+
+	// Unknown commands may be plugins, cobra would fail on them
+	if ran, err := runPlugin(os.Args[1:]); ran {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootCtx = ctx
+
+	// First signal cancels gracefully, a second one exits right away
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		log.Warn("Interrupted, canceling, press Ctrl-C again to exit now")
+		cancel()
+		<-signals
+		log.Exit(ExitInterrupted)
+	}()
+
+	err = rootCli.Execute()
+	if err != nil && ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if writeErr := writeResults(); err == nil {
+		err = writeErr
 	}
+	return err
 }
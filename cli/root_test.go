@@ -0,0 +1,390 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSavedConfigIsPrivate(t *testing.T) {
+	e := newTestEnv(t)
+	e.mustRun("config", "set", "default.secret_key", "s3cret")
+
+	info, err := os.Stat(e.configFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("config saved with mode %o, expected 600", mode)
+	}
+}
+
+func TestSaveKeepsOnlyStricterMode(t *testing.T) {
+	for _, tc := range []struct {
+		existing, expected os.FileMode
+	}{
+		{0644, 0600},
+		{0660, 0600},
+		{0600, 0600},
+		{0400, 0400},
+	} {
+		e := newTestEnv(t)
+		e.writeConfig(fmt.Sprintf("schema_version: %d\n", schemaVersion))
+		if err := os.Chmod(e.configFile(), tc.existing); err != nil {
+			t.Fatal(err)
+		}
+		e.mustRun("config", "set", "default.username", "bob")
+
+		info, err := os.Stat(e.configFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != tc.expected {
+			t.Errorf("config of mode %o saved as %o, expected %o", tc.existing, mode, tc.expected)
+		}
+	}
+}
+
+func TestFailedWriteKeepsConfig(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.yaml")
+	old := "default:\n  username: bob\n"
+	if err := ioutil.WriteFile(fileName, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write dying halfway leaves a truncated temporary file behind it
+	err := writeFileAtomic(fileName, func(tmpName string) error {
+		ioutil.WriteFile(tmpName, []byte("default:\n  user"), 0600)
+		return errors.New("disk full")
+	})
+	if !errors.Is(err, ErrConfigWrite) {
+		t.Fatalf("expected ErrConfigWrite, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != old {
+		t.Errorf("config changed by a failed write: %q", data)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("temporary file left behind, found %d files", len(files))
+	}
+}
+
+func TestSaveConfigIntoNewDirectory(t *testing.T) {
+	e := newTestEnv(t)
+	dir := filepath.Join(e.home, "new", "dir")
+	fileName := filepath.Join(dir, "config.yaml")
+
+	e.mustRun("--config", fileName, "config", "set", "default.username", "bob")
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0700 {
+		t.Errorf("config directory created with mode %o, expected 700", mode)
+	}
+	if _, err := os.Stat(e.configFile()); !os.IsNotExist(err) {
+		t.Errorf("default config written instead of --config: %v", err)
+	}
+	// Relative paths resolve the same for reading and saving
+	result := e.mustRun("--config", "new/dir/config.yaml", "config", "get", "default.username")
+	if result.stdout != "bob\n" {
+		t.Errorf("unexpected value read back: %q", result.stdout)
+	}
+}
+
+func TestNoRewriteAfterInit(t *testing.T) {
+	e := newTestEnv(t)
+	e.mustRun("config", "init")
+
+	result := e.mustRun("--no-rewrite", "config", "set", "default.username", "bob")
+	if strings.Contains(result.stderr, "dropped") {
+		t.Errorf("comments of config init reported as lost: %s", result.stderr)
+	}
+	result = e.mustRun("config", "set", "default.username", "alice")
+	if strings.Contains(result.stderr, "dropped") {
+		t.Errorf("comments of config init reported as lost: %s", result.stderr)
+	}
+}
+
+func TestNoRewriteHandWritten(t *testing.T) {
+	for _, config := range []string{
+		fmt.Sprintf("schema_version: %d\n# my notes\ndefault:\n  username: bob\n", schemaVersion),
+		fmt.Sprintf("schema_version: %d\nbase: &base\n  username: bob\ndefault:\n  <<: *base\n", schemaVersion),
+	} {
+		e := newTestEnv(t)
+		e.writeConfig(config)
+
+		if result := e.run("--no-rewrite", "config", "set", "default.username", "alice"); result.code != ExitConfig {
+			t.Errorf("hand written config saved with --no-rewrite, exit code %d: %s", result.code, result.stderr)
+		}
+		if e.readConfig() != config {
+			t.Errorf("config changed with --no-rewrite:\n%s", e.readConfig())
+		}
+		if result := e.mustRun("config", "set", "default.username", "alice"); !strings.Contains(result.stderr, "dropped") {
+			t.Errorf("no warning about dropped parts: %s", result.stderr)
+		}
+	}
+}
+
+func TestBackupIsPrivate(t *testing.T) {
+	for _, tc := range []struct {
+		existing, expected os.FileMode
+		timestamped        bool
+	}{
+		{0644, 0600, false},
+		{0600, 0600, false},
+		{0400, 0400, false},
+		{0644, 0600, true},
+	} {
+		e := newTestEnv(t)
+		e.writeConfig(fmt.Sprintf("schema_version: %d\ndefault:\n  secret_key: s3cret\n", schemaVersion))
+		if err := os.Chmod(e.configFile(), tc.existing); err != nil {
+			t.Fatal(err)
+		}
+		// A backup left by an older clh
+		if err := ioutil.WriteFile(e.configFile()+".bak", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"config", "set", "default.username", "bob"}
+		pattern := e.configFile() + ".bak"
+		if tc.timestamped {
+			args = append(args, "--backup")
+			pattern = e.configFile() + ".*.bak"
+		}
+		e.mustRun(args...)
+
+		backups, err := filepath.Glob(pattern)
+		if err != nil || len(backups) != 1 {
+			t.Fatalf("expected a backup %s, found %v: %v", pattern, backups, err)
+		}
+		info, err := os.Stat(backups[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != tc.expected {
+			t.Errorf("backup of a config of mode %o saved as %o, expected %o", tc.existing, mode, tc.expected)
+		}
+	}
+}
+
+func TestInvalidLogSettingsWarnOnce(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"--log_level", "loud"}, "Error in log level parsing"},
+		{[]string{"--log_format", "xml"}, "Unknown log format"},
+		{[]string{"--log_file", "/nonexistent/clh.log"}, "Can't open log file"},
+	} {
+		e := newTestEnv(t)
+		// Set in the config too, so every phase sees it
+		e.writeConfig(fmt.Sprintf("schema_version: %d\n%s: %s\n", schemaVersion, strings.TrimPrefix(tc.args[0], "--"), tc.args[1]))
+
+		result := e.mustRun(append(tc.args, "version", "--short")...)
+		if n := strings.Count(result.stderr, tc.message); n != 1 {
+			t.Errorf("%s reported %d times: %s", strings.Join(tc.args, " "), n, result.stderr)
+		}
+	}
+}
+
+// A broken config is reported, yet commands still run so it can be fixed
+func TestBrokenConfig(t *testing.T) {
+	e := newTestEnv(t)
+	e.writeConfig("default: [unclosed\n")
+
+	result := e.mustRun("version", "--short")
+	if !strings.Contains(result.stderr, "can't read config") {
+		t.Errorf("broken config not reported: %q", result.stderr)
+	}
+	e.mustRun("config", "init", "--force")
+	if result := e.mustRun("config", "get", "context"); result.stdout != "default\n" {
+		t.Errorf("config not fixed by init: %q", result.stdout)
+	}
+}
+
+// Dry runs print the config a command would save and leave the file alone
+func TestDryRun(t *testing.T) {
+	config := fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  username: bob\n  secret_key: s3cret\nprod:\n  username: alice\n", schemaVersion)
+	for _, tc := range []struct {
+		args     []string
+		wanted   []string
+		unwanted []string
+	}{
+		{[]string{"config", "set", "dev.username", "carol"}, []string{"username: carol"}, []string{"username: bob"}},
+		{[]string{"config", "unset", "prod.username"}, []string{"username: bob"}, []string{"alice"}},
+		{[]string{"use-context", "prod"}, []string{"context: prod"}, []string{"context: dev"}},
+		{[]string{"context", "delete", "prod"}, []string{"dev:"}, []string{"prod:"}},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			e := newTestEnv(t)
+			e.writeConfig(config)
+
+			result := e.mustRun(append(tc.args, "--dry-run")...)
+			if !strings.Contains(result.stdout, "# Dry run, "+e.configFile()+" would be:") {
+				t.Errorf("dry run not announced: %q", result.stdout)
+			}
+			for _, wanted := range tc.wanted {
+				if !strings.Contains(result.stdout, wanted) {
+					t.Errorf("dry run misses %q: %q", wanted, result.stdout)
+				}
+			}
+			for _, unwanted := range append(tc.unwanted, "s3cret") {
+				if strings.Contains(result.stdout, unwanted) {
+					t.Errorf("dry run shows %q: %q", unwanted, result.stdout)
+				}
+			}
+			if saved := e.readConfig(); saved != config {
+				t.Errorf("config saved on a dry run:\n%s", saved)
+			}
+		})
+	}
+}
+
+func TestNoConfig(t *testing.T) {
+	config := fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  username: bob\n", schemaVersion)
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  []string
+	}{
+		{"flag", []string{"--no-config"}, nil},
+		{"env", nil, []string{"CLH_NO_CONFIG=1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.writeConfig(config)
+			e.env = append(e.env, tc.env...)
+
+			if current := e.mustRun(append(tc.args, "context", "current")...).stdout; current != "default\n" {
+				t.Errorf("context of the config file used: %q", current)
+			}
+			e.env = append(e.env, "CLH_CONTEXT=ci")
+			if current := e.mustRun(append(tc.args, "context", "current")...).stdout; current != "ci\n" {
+				t.Errorf("context of env not used: %q", current)
+			}
+
+			result := e.run(append(tc.args, "config", "set", "log_level", "debug")...)
+			if result.code != ExitConfig || !strings.Contains(result.logs(), "config files are disabled with --no-config") {
+				t.Errorf("exit code %d, expected %d for a save: %s", result.code, ExitConfig, result.stderr)
+			}
+			if saved := e.readConfig(); saved != config {
+				t.Errorf("config saved with config files disabled:\n%s", saved)
+			}
+		})
+	}
+}
+
+func TestStdinConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		env   []string
+		stdin string
+	}{
+		{"yaml", []string{"--config", "-"}, nil, "context: piped\npiped:\n  username: bob\n"},
+		{"json", []string{"--config", "-", "--config-type", "json"}, nil, `{"context": "piped", "piped": {"username": "bob"}}`},
+		{"env", nil, []string{"CLH_CONFIG=-"}, "context: piped\npiped:\n  username: bob\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.env = append(e.env, tc.env...)
+			e.stdin = tc.stdin
+
+			if current := e.mustRun(append(tc.args, "context", "current")...).stdout; current != "piped\n" {
+				t.Errorf("context of stdin not used: %q", current)
+			}
+			if username := e.mustRun(append(tc.args, "config", "get", "piped.username")...).stdout; username != "bob\n" {
+				t.Errorf("username of stdin not used: %q", username)
+			}
+		})
+	}
+}
+
+// A config read from stdin is saved to --save-to only, with the change on top of it
+func TestStdinConfigSave(t *testing.T) {
+	e := newTestEnv(t)
+	e.stdin = fmt.Sprintf("schema_version: %d\ncontext: piped\npiped:\n  username: bob\n", schemaVersion)
+
+	result := e.run("--config", "-", "config", "set", "piped.username", "alice")
+	if result.code != ExitConfig || !strings.Contains(result.logs(), "use --save-to <file>") {
+		t.Errorf("exit code %d, expected %d without --save-to: %s", result.code, ExitConfig, result.stderr)
+	}
+
+	fileName := filepath.Join(e.home, "saved.yaml")
+	e.mustRun("--config", "-", "--save-to", fileName, "config", "set", "piped.username", "alice")
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := string(data); !strings.Contains(saved, "context: piped") || !strings.Contains(saved, "username: alice") {
+		t.Errorf("piped config not saved with the change:\n%s", saved)
+	}
+	if _, err := os.Stat(e.configFile()); !os.IsNotExist(err) {
+		t.Errorf("standard config written: %v", err)
+	}
+}
+
+// --config-dir is the only directory read and saved, the standard files are left out
+func TestConfigDir(t *testing.T) {
+	config := fmt.Sprintf("schema_version: %d\ncontext: dev\ndev:\n  username: bob\n", schemaVersion)
+	custom := fmt.Sprintf("schema_version: %d\ncontext: ops\nops:\n  username: carol\n", schemaVersion)
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		env     []string
+		content string
+		context string
+		wanted  []string
+	}{
+		{"flag", []string{"--config-dir", "custom"}, nil, custom, "ops", []string{"context: ops", "username: carol"}},
+		{"env", nil, []string{"CLH_CONFIG_DIR=custom"}, custom, "ops", []string{"context: ops", "username: carol"}},
+		{"empty", []string{"--config-dir", "custom"}, nil, "", "default", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.writeConfig(config)
+			e.env = append(e.env, tc.env...)
+			dir := filepath.Join(e.home, "custom")
+			fileName := filepath.Join(dir, "config.yaml")
+			if tc.content != "" {
+				if err := os.MkdirAll(dir, 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(fileName, []byte(tc.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if current := e.mustRun(append(tc.args, "context", "current")...).stdout; current != tc.context+"\n" {
+				t.Errorf("current context is %q, expected %q", current, tc.context)
+			}
+			e.mustRun(append(tc.args, "config", "set", "log_level", "debug")...)
+			data, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, wanted := range append(tc.wanted, "log_level: debug") {
+				if !strings.Contains(string(data), wanted) {
+					t.Errorf("config dir misses %q:\n%s", wanted, data)
+				}
+			}
+			if saved := e.readConfig(); saved != config {
+				t.Errorf("standard config changed:\n%s", saved)
+			}
+		})
+	}
+}
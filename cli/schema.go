@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// JSON Schema of config files checked by `clh config validate --strict`,
+// keep it in sync with globalKeys and contextKeys. Only the subset of draft-07
+// implemented by validateSchema is used.
+const configSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "clh config",
+  "type": "object",
+  "properties": {
+    "schema_version": {"type": "integer"},
+    "context": {"type": "string"},
+    "default_endpoint": {"type": "string"},
+    "log_level": {"enum": ["panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"]},
+    "log_format": {"enum": ["text", "json"]},
+    "log_color": {"enum": ["auto", "always", "never"]},
+    "log_file": {"type": "string"},
+    "log_stdout": {"type": "boolean"},
+    "output": {"$ref": "#/definitions/output"},
+    "timeout": {"type": "string"},
+    "proxy": {"type": "string"},
+    "insecure": {"type": "boolean"},
+    "cacert": {"type": "string"},
+    "retries": {"type": "integer"},
+    "retry_unsafe": {"type": "boolean"},
+    "update_url": {"type": "string"},
+    "backups": {"type": "integer"}
+  },
+  "additionalProperties": {"$ref": "#/definitions/context"},
+  "definitions": {
+    "output": {"enum": ["table", "json", "yaml"]},
+    "context": {
+      "title": "a context section",
+      "type": "object",
+      "properties": {
+        "endpoint": {"type": "string"},
+        "endpoints": {"type": "array", "items": {"type": "string"}},
+        "username": {"type": "string"},
+        "secret_key": {"type": "string"},
+        "token": {"type": "string"},
+        "output": {"$ref": "#/definitions/output"},
+        "credential_helper": {"type": "string"},
+        "insecure_endpoints": {"type": "array", "items": {"type": "string"}}
+      },
+      "additionalProperties": false
+    }
+  }
+}`
+
+// schema is a node of configSchema
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Title                string             `json:"title"`
+	Type                 string             `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Definitions          map[string]*schema `json:"definitions"`
+}
+
+// validateConfigSchema returns problems of settings read from a config file, sorted by key
+func validateConfigSchema(settings map[string]interface{}) ([]string, error) {
+	var root schema
+	if err := json.Unmarshal([]byte(configSchema), &root); err != nil {
+		return nil, fmt.Errorf("invalid config schema: %v", err)
+	}
+	problems, err := validateSchema(&root, &root, "", settings)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// keyType returns the JSON type of a key like retries or prod.username in configSchema,
+// empty if the schema doesn't tell one
+func keyType(key string) (string, error) {
+	_, s, err := keySchema(key)
+	if err != nil || s == nil {
+		return "", err
+	}
+	return s.Type, nil
+}
+
+// validateKeyValue returns problems of a value about to be set for key, e.g. an output
+// which is not one of the known formats
+func validateKeyValue(key string, value interface{}) ([]string, error) {
+	root, s, err := keySchema(key)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return validateSchema(root, s, key, value)
+}
+
+// keySchema returns configSchema and the schema of key in it, nil if the schema doesn't
+// tell one
+func keySchema(key string) (*schema, *schema, error) {
+	var root schema
+	if err := json.Unmarshal([]byte(configSchema), &root); err != nil {
+		return nil, nil, fmt.Errorf("invalid config schema: %v", err)
+	}
+	s := &root
+	for _, name := range strings.Split(key, ".") {
+		sub, err := propertySchema(s, name)
+		if err != nil || sub == nil {
+			return &root, nil, err
+		}
+		if sub.Ref != "" {
+			def, ok := root.Definitions[strings.TrimPrefix(sub.Ref, "#/definitions/")]
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid config schema: unknown $ref %q", sub.Ref)
+			}
+			sub = def
+		}
+		s = sub
+	}
+	return &root, s, nil
+}
+
+// validateSchema checks value against s, key is the dotted path of value
+func validateSchema(root, s *schema, key string, value interface{}) ([]string, error) {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		def, ok := root.Definitions[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid config schema: unknown $ref %q", s.Ref)
+		}
+		s = def
+	}
+
+	if s.Type != "" && !schemaType(s.Type, value) {
+		expected := s.Type
+		if s.Title != "" {
+			expected = s.Title
+		}
+		return []string{fmt.Sprintf("%s: expected %s, got %s", schemaKey(key), expected, jsonType(value))}, nil
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		var values []string
+		for _, v := range s.Enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		return []string{fmt.Sprintf("%s: %v is not one of: %s", schemaKey(key), value, strings.Join(values, ", "))}, nil
+	}
+
+	var problems []string
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range sortedKeys(value) {
+			path := name
+			if key != "" {
+				path = key + "." + name
+			}
+			sub, err := propertySchema(s, name)
+			if err != nil {
+				return nil, err
+			}
+			if sub == nil {
+				problems = append(problems, path+": unknown key")
+				continue
+			}
+			found, err := validateSchema(root, sub, path, value[name])
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, found...)
+		}
+	case []interface{}:
+		if s.Items == nil {
+			break
+		}
+		for i, item := range value {
+			found, err := validateSchema(root, s.Items, fmt.Sprintf("%s[%d]", key, i), item)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, found...)
+		}
+	}
+	return problems, nil
+}
+
+// propertySchema returns the schema of a property, nil if it is not allowed
+func propertySchema(s *schema, name string) (*schema, error) {
+	if sub, ok := s.Properties[name]; ok {
+		return sub, nil
+	}
+	additional := strings.TrimSpace(string(s.AdditionalProperties))
+	switch additional {
+	case "", "true":
+		return &schema{}, nil
+	case "false":
+		return nil, nil
+	}
+	var sub schema
+	if err := json.Unmarshal(s.AdditionalProperties, &sub); err != nil {
+		return nil, fmt.Errorf("invalid config schema: %v", err)
+	}
+	return &sub, nil
+}
+
+// schemaType tells if value has a JSON type, numbers decode differently in yaml, json and toml
+func schemaType(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		switch v := value.(type) {
+		case int, int64:
+			return true
+		case float64:
+			return v == math.Trunc(v)
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// jsonType names the JSON type of a decoded value for messages
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, float64:
+		return "number"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, v := range enum {
+		if fmt.Sprint(v) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaKey(key string) string {
+	if key == "" {
+		return "config"
+	}
+	return key
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Latest release is looked up here unless update_url is set
+const defaultUpdateURL = "https://api.github.com/repos/CloudletLabs/clh-cli/releases/latest"
+
+// latestVersion fetches the tag of the latest release from update_url
+func latestVersion() (string, error) {
+	timeout, err := requestTimeout()
+	if err != nil {
+		return "", err
+	}
+	transport, err := newTransport()
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: transport}
+	req, err := http.NewRequestWithContext(rootCtx, http.MethodGet, viper.GetString("update_url"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("update check failed: %s", resp.Status)
+	}
+
+	// GitHub releases have tag_name, a plain update server may answer with version
+	var release struct {
+		TagName string `json:"tag_name"`
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("can't decode release: %v", err)
+	}
+	if release.TagName != "" {
+		return release.TagName, nil
+	}
+	if release.Version != "" {
+		return release.Version, nil
+	}
+	return "", errors.New("no version in release")
+}
+
+// newerVersion tells if latest is a higher vX.Y.Z than current, numbers are compared
+// part by part and anything after a dash or plus is ignored
+func newerVersion(latest, current string) bool {
+	l, c := versionParts(latest), versionParts(current)
+	for i := 0; i < len(l) || i < len(c); i++ {
+		var lp, cp int
+		if i < len(l) {
+			lp = l[i]
+		}
+		if i < len(c) {
+			cp = c[i]
+		}
+		if lp != cp {
+			return lp > cp
+		}
+	}
+	return false
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, part := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(part)
+		parts = append(parts, n)
+	}
+	return parts
+}
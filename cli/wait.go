@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Delay between polls of a running operation
+const waitInterval = 2 * time.Second
+
+// Statuses of operations which are over
+var finalStatuses = map[string]bool{
+	"succeeded": true,
+	"failed":    true,
+	"canceled":  true,
+}
+
+// operation is what the API returns for asynchronous requests, e.g. a deployment
+type operation struct {
+	ID      string `json:"id" yaml:"id"`
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+func (o operation) rows() [][]string {
+	rows := [][]string{
+		{"id:", o.ID},
+		{"status:", o.Status},
+	}
+	if o.Message != "" {
+		rows = append(rows, []string{"message:", o.Message})
+	}
+	return rows
+}
+
+func (o operation) done() bool {
+	return finalStatuses[o.Status]
+}
+
+// addWaitFlag lets a command starting an operation wait for it with --wait
+func addWaitFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolP("wait", "", false, "Wait for the operation to finish, up to --timeout")
+}
+
+// renderOperation prints an operation started by cmd, with --wait only once it is over.
+// A failed operation is an error.
+func renderOperation(cmd *cobra.Command, c *apiClient, op *operation, path string) error {
+	if wait, _ := cmd.Flags().GetBool("wait"); wait && !op.done() {
+		var err error
+		if op, err = c.waitOperation(path, op); err != nil {
+			return err
+		}
+	}
+	if err := render(op); err != nil {
+		return err
+	}
+	if op.done() && op.Status != "succeeded" {
+		return fmt.Errorf("operation %s %s", op.ID, op.Status)
+	}
+	return nil
+}
+
+// waitOperation polls path until the operation is over or --timeout elapses,
+// zero timeout waits as long as it takes
+func (c *apiClient) waitOperation(path string, op *operation) (*operation, error) {
+	timeout, err := requestTimeout()
+	if err != nil {
+		return nil, err
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+
+	p := startProgress(waitMessage(op))
+	defer p.stop()
+	for !op.done() {
+		select {
+		case <-time.After(waitInterval):
+		case <-deadline:
+			return nil, fmt.Errorf("operation %s is still %s after %s", op.ID, op.Status, timeout)
+		case <-rootCtx.Done():
+			return nil, ErrInterrupted
+		}
+
+		next := &operation{}
+		if err := c.Do("GET", path, nil, next); err != nil {
+			return nil, err
+		}
+		if next.Status == "" {
+			return nil, errors.New("operation status missing in response")
+		}
+		op = next
+		if !op.done() {
+			p.update(waitMessage(op))
+		}
+	}
+	p.stop()
+	log.Info("Operation ", op.ID, " ", op.Status)
+	return op, nil
+}
+
+func waitMessage(op *operation) string {
+	return fmt.Sprintf("Operation %s is %s, waiting", op.ID, op.Status)
+}